@@ -1,7 +1,12 @@
 package main
 
 import (
+	"flag"
+	"fmt"
 	"log"
+	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/Henshou/Tubes2_BE_CraftingTable.git/recipe"
 	"github.com/Henshou/Tubes2_BE_CraftingTable.git/scraper"
@@ -9,17 +14,90 @@ import (
 )
 
 func main() {
-	log.Println("Scraping recipes…")
-	scraper.FindRecipes()
-	log.Println("Finished scraping; wrote recipes.json")
+	opts := scraper.DefaultOptions()
+	flag.BoolVar(&opts.Details, "details", false, "also scrape every element's wiki page for its metadata")
+	flag.StringVar(&opts.RejectsPath, "rejects", opts.RejectsPath, "where to write the report of rows the scraper dropped, relative to -data-dir")
+	flag.StringVar(&opts.ImageDir, "image-dir", "", "directory to mirror element icons into and serve them from, relative to -data-dir (disabled when empty)")
+	flag.StringVar(&opts.UserAgent, "user-agent", opts.UserAgent, "User-Agent sent to the wiki")
+	flag.StringVar(&opts.CacheDir, "cache-dir", "", "directory to cache HTTP responses in (disabled when empty)")
+	flag.DurationVar(&opts.Timeout, "request-timeout", opts.Timeout, "timeout of a single request to the wiki")
+	flag.DurationVar(&opts.Delay, "delay", opts.Delay, "pause between requests to the wiki")
+	flag.IntVar(&opts.Parallelism, "parallelism", opts.Parallelism, "concurrent requests when scraping element pages")
+	flag.IntVar(&opts.Retries, "retries", opts.Retries, "retries for a failed request")
+	flag.DurationVar(&opts.Backoff, "backoff", opts.Backoff, "wait before the first retry, doubled on each further retry")
+	loadConfig := server.ConfigFlags(flag.CommandLine)
+	sources := flag.String("sources", "la2", "comma-separated datasets to scrape: la2, la1 or name=path for a CSV/JSON recipe list")
+	exportFormat := flag.String("export", "", "instead of scraping and serving, export the recipe graph (or with -target a search tree) as "+strings.Join(recipe.ExportFormats, ", "))
+	exportDataset := flag.String("dataset", recipe.DefaultDataset, "dataset to export")
+	exportTarget := flag.String("target", "", "element whose search tree is exported")
+	exportMethod := flag.String("method", "bfs", "search method used for -target")
+	exportCount := flag.Int("count", 1, "recipes to search for with -target")
+	exportOut := flag.String("out", "", "file to write the export to (stdout when empty)")
+	flag.Parse()
 
-	var err error
-	recipe.RecipeMap, err = recipe.ReadJson("recipes.json")
+	cfg, err := loadConfig()
 	if err != nil {
-		log.Fatalf("Failed to load recipes.json: %v", err)
+		log.Fatalf("Invalid configuration: %v", err)
 	}
-	log.Printf("Loaded %d recipes.\n", len(recipe.RecipeMap))
+	server.ConfigureLogging(cfg)
+	opts.OutputDir = cfg.DataDir
+	server.ScrapeOptions = opts
 
-	log.Println("Starting HTTP server on :8080")
-	server.Start()
+	if *exportFormat != "" {
+		if err := exportGraph(*exportFormat, cfg.DataDir, *exportDataset, *exportTarget, *exportMethod, *exportCount, *exportOut); err != nil {
+			log.Fatalf("Export failed: %v", err)
+		}
+		return
+	}
+
+	var srcs []scraper.Source
+	for _, spec := range strings.Split(*sources, ",") {
+		src, err := scraper.SourceByName(strings.TrimSpace(spec))
+		if err != nil {
+			log.Fatal(err)
+		}
+		srcs = append(srcs, src)
+	}
+
+	if opts.ImageDir != "" {
+		server.ImageDir = opts.ImageDir
+	}
+
+	// The server listens while the scrape runs, reporting not ready.
+	log.Printf("Starting HTTP server on %s\n", cfg.Addr)
+	server.Start(cfg, func() error {
+		for _, src := range srcs {
+			log.Printf("Scraping recipes for %s…", src.Name())
+			if err := scraper.FindRecipes(src, opts); err != nil {
+				return fmt.Errorf("scraping %s: %w", src.Name(), err)
+			}
+		}
+		log.Println("Finished scraping")
+		return nil
+	})
+}
+
+func exportGraph(format, dataDir, dataset, target, method string, count int, out string) error {
+	recipes, err := recipe.ReadJson(filepath.Join(dataDir, recipe.DatasetFile(dataset)))
+	if err != nil {
+		return err
+	}
+
+	graph := recipe.RecipeGraph(recipes)
+	if target != "" {
+		root, err := server.SearchTree(recipes, target, method, count, 0)
+		if err != nil {
+			return err
+		}
+		graph = recipe.TreeGraph(root, recipes)
+	}
+
+	w := os.Stdout
+	if out != "" {
+		if w, err = os.Create(out); err != nil {
+			return err
+		}
+		defer w.Close()
+	}
+	return recipe.WriteGraph(w, graph, format)
 }
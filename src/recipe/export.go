@@ -0,0 +1,235 @@
+package recipe
+
+import (
+	"bufio"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	FormatDOT       = "dot"
+	FormatMermaid   = "mermaid"
+	FormatGraphML   = "graphml"
+	FormatCytoscape = "cytoscape"
+
+	NodeElement = "element"
+	NodeRecipe  = "recipe"
+)
+
+var ExportFormats = []string{FormatDOT, FormatMermaid, FormatGraphML, FormatCytoscape}
+
+// Graph is a recipe tree or a whole recipe map as nodes and edges. Every
+// recipe gets a node of its own, with an edge from each ingredient to it and
+// one from it to the element it makes.
+type Graph struct {
+	Nodes []GraphNode
+	Edges []GraphEdge
+}
+
+type GraphNode struct {
+	ID    string
+	Label string
+	Kind  string
+	Tier  int
+}
+
+type GraphEdge struct {
+	Source string
+	Target string
+}
+
+// TreeGraph turns a search tree into a graph. An element that shows up more
+// than once in the tree gets a node for every place it shows up.
+func TreeGraph(root *RecipeTreeNode, recipeMap map[string]Recipe) Graph {
+	var g Graph
+	var walk func(node *RecipeTreeNode) string
+	walk = func(node *RecipeTreeNode) string {
+		id := "n" + strconv.Itoa(len(g.Nodes)+1)
+		g.Nodes = append(g.Nodes, GraphNode{ID: id, Label: node.Name, Kind: NodeElement, Tier: recipeMap[node.Name].Tier})
+		for _, group := range node.Children {
+			if len(group) == 0 {
+				continue
+			}
+			recipeID := g.addRecipe(id)
+			for _, child := range group {
+				g.Edges = append(g.Edges, GraphEdge{Source: walk(child), Target: recipeID})
+			}
+		}
+		return id
+	}
+	if root != nil {
+		walk(root)
+	}
+	return g
+}
+
+// RecipeGraph turns a whole recipe map into a graph with one node per
+// element.
+func RecipeGraph(recipeMap map[string]Recipe) Graph {
+	names := make([]string, 0, len(recipeMap))
+	for name := range recipeMap {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var g Graph
+	ids := make(map[string]string, len(names))
+	for i, name := range names {
+		ids[name] = "e" + strconv.Itoa(i+1)
+		g.Nodes = append(g.Nodes, GraphNode{ID: ids[name], Label: name, Kind: NodeElement, Tier: recipeMap[name].Tier})
+	}
+	for _, name := range names {
+		for _, pair := range recipeMap[name].Recipes {
+			// Base elements carry an empty recipe, and a recipe may name
+			// only elements the dataset lacks; neither gets a junction.
+			var inputs []string
+			for _, ingredient := range pair {
+				if id, ok := ids[ingredient]; ok {
+					inputs = append(inputs, id)
+				}
+			}
+			if len(inputs) == 0 {
+				continue
+			}
+			recipeID := g.addRecipe(ids[name])
+			for _, id := range inputs {
+				g.Edges = append(g.Edges, GraphEdge{Source: id, Target: recipeID})
+			}
+		}
+	}
+	return g
+}
+
+func (g *Graph) addRecipe(product string) string {
+	id := "r" + strconv.Itoa(len(g.Nodes)+1)
+	g.Nodes = append(g.Nodes, GraphNode{ID: id, Label: "+", Kind: NodeRecipe})
+	g.Edges = append(g.Edges, GraphEdge{Source: id, Target: product})
+	return id
+}
+
+// ContentType is the MIME type of an export format.
+func ContentType(format string) string {
+	switch format {
+	case FormatDOT:
+		return "text/vnd.graphviz; charset=utf-8"
+	case FormatMermaid:
+		return "text/vnd.mermaid; charset=utf-8"
+	case FormatGraphML:
+		return "application/graphml+xml"
+	case FormatCytoscape:
+		return "application/json"
+	}
+	return "application/octet-stream"
+}
+
+func WriteGraph(w io.Writer, g Graph, format string) error {
+	switch format {
+	case FormatDOT:
+		return writeDOT(w, g)
+	case FormatMermaid:
+		return writeMermaid(w, g)
+	case FormatGraphML:
+		return writeGraphML(w, g)
+	case FormatCytoscape:
+		return writeCytoscape(w, g)
+	}
+	return fmt.Errorf("unknown export format %q, want one of %s", format, strings.Join(ExportFormats, ", "))
+}
+
+func writeDOT(w io.Writer, g Graph) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "digraph recipes {")
+	fmt.Fprintln(bw, "  rankdir=BT;")
+	fmt.Fprintln(bw, "  node [shape=box, style=rounded];")
+	for _, n := range g.Nodes {
+		if n.Kind == NodeRecipe {
+			fmt.Fprintf(bw, "  %s [shape=point, label=\"\"];\n", n.ID)
+		} else {
+			fmt.Fprintf(bw, "  %s [label=%s, tier=%d];\n", n.ID, strconv.Quote(n.Label), n.Tier)
+		}
+	}
+	for _, e := range g.Edges {
+		fmt.Fprintf(bw, "  %s -> %s;\n", e.Source, e.Target)
+	}
+	fmt.Fprintln(bw, "}")
+	return bw.Flush()
+}
+
+func writeMermaid(w io.Writer, g Graph) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "flowchart BT")
+	for _, n := range g.Nodes {
+		if n.Kind == NodeRecipe {
+			fmt.Fprintf(bw, "  %s((+))\n", n.ID)
+		} else {
+			fmt.Fprintf(bw, "  %s[\"%s\"]\n", n.ID, strings.ReplaceAll(n.Label, `"`, "#quot;"))
+		}
+	}
+	for _, e := range g.Edges {
+		fmt.Fprintf(bw, "  %s --> %s\n", e.Source, e.Target)
+	}
+	return bw.Flush()
+}
+
+func writeGraphML(w io.Writer, g Graph) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, xml.Header+`<graphml xmlns="http://graphml.graphdrawing.org/xmlns">`)
+	fmt.Fprintln(bw, `  <key id="label" for="node" attr.name="label" attr.type="string"/>`)
+	fmt.Fprintln(bw, `  <key id="kind" for="node" attr.name="kind" attr.type="string"/>`)
+	fmt.Fprintln(bw, `  <key id="tier" for="node" attr.name="tier" attr.type="int"/>`)
+	fmt.Fprintln(bw, `  <graph id="recipes" edgedefault="directed">`)
+	for _, n := range g.Nodes {
+		fmt.Fprintf(bw, `    <node id="%s"><data key="label">`, n.ID)
+		xml.EscapeText(bw, []byte(n.Label))
+		fmt.Fprintf(bw, `</data><data key="kind">%s</data><data key="tier">%d</data></node>`+"\n", n.Kind, n.Tier)
+	}
+	for i, e := range g.Edges {
+		fmt.Fprintf(bw, `    <edge id="x%d" source="%s" target="%s"/>`+"\n", i+1, e.Source, e.Target)
+	}
+	fmt.Fprintln(bw, "  </graph>")
+	fmt.Fprintln(bw, "</graphml>")
+	return bw.Flush()
+}
+
+type cytoscapeNode struct {
+	Data struct {
+		ID    string `json:"id"`
+		Label string `json:"label"`
+		Kind  string `json:"kind"`
+		Tier  int    `json:"tier"`
+	} `json:"data"`
+}
+
+type cytoscapeEdge struct {
+	Data struct {
+		ID     string `json:"id"`
+		Source string `json:"source"`
+		Target string `json:"target"`
+	} `json:"data"`
+}
+
+// writeCytoscape writes the elements object accepted by cytoscape({elements}).
+func writeCytoscape(w io.Writer, g Graph) error {
+	var out struct {
+		Elements struct {
+			Nodes []cytoscapeNode `json:"nodes"`
+			Edges []cytoscapeEdge `json:"edges"`
+		} `json:"elements"`
+	}
+	out.Elements.Nodes = make([]cytoscapeNode, len(g.Nodes))
+	for i, n := range g.Nodes {
+		d := &out.Elements.Nodes[i].Data
+		d.ID, d.Label, d.Kind, d.Tier = n.ID, n.Label, n.Kind, n.Tier
+	}
+	out.Elements.Edges = make([]cytoscapeEdge, len(g.Edges))
+	for i, e := range g.Edges {
+		d := &out.Elements.Edges[i].Data
+		d.ID, d.Source, d.Target = "x"+strconv.Itoa(i+1), e.Source, e.Target
+	}
+	return json.NewEncoder(w).Encode(out)
+}
@@ -1,9 +1,10 @@
 package recipe
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
 	"strconv"
 	"sync"
@@ -11,9 +12,16 @@ import (
 )
 
 type Recipe struct {
-	Name    string     `json:"element"`
-	Recipes [][]string `json:"recipes"`
-	Tier    int        `json:"tier"`
+	Name        string     `json:"element"`
+	Recipes     [][]string `json:"recipes"`
+	Tier        int        `json:"tier"`
+	ImageURL    string     `json:"image_url"`
+	ImageFile   string     `json:"image_file,omitempty"`
+	PageURL     string     `json:"page_url,omitempty"`
+	Description string     `json:"description,omitempty"`
+	UsedIn      []string   `json:"used_in,omitempty"`
+	Categories  []string   `json:"categories,omitempty"`
+	Pack        string     `json:"pack,omitempty"`
 }
 
 type RecipeTreeNode struct {
@@ -25,8 +33,18 @@ var VisitedMap = make(map[string]*RecipeTreeNode)
 var RecipeMap = make(map[string]Recipe)
 var CompletedRecipes = make(map[string]int)
 
-func IsBaseElement(name string) bool {
-	recipe, exists := RecipeMap[name]
+const DefaultDataset = "la2"
+
+// DatasetFile is the file a dataset is scraped into and loaded from.
+func DatasetFile(name string) string {
+	if name == DefaultDataset {
+		return "recipes.json"
+	}
+	return "recipes_" + name + ".json"
+}
+
+func IsBaseElement(name string, recipeMap map[string]Recipe) bool {
+	recipe, exists := recipeMap[name]
 	if !exists {
 		return false
 	}
@@ -89,12 +107,12 @@ func ReadJson(filename string) (map[string]Recipe, error) {
 	return recipeMap, nil
 }
 
-func CalculateTotalCompleteRecipes(root *RecipeTreeNode) int {
+func CalculateTotalCompleteRecipes(root *RecipeTreeNode, recipeMap map[string]Recipe) int {
 	if root == nil {
 		return 0
 	}
 
-	if IsBaseElement(root.Name) {
+	if IsBaseElement(root.Name, recipeMap) {
 		return 1
 	}
 
@@ -103,8 +121,8 @@ func CalculateTotalCompleteRecipes(root *RecipeTreeNode) int {
 		if len(group) != 2 {
 			continue
 		}
-		leftCount := CalculateTotalCompleteRecipes(group[0])
-		rightCount := CalculateTotalCompleteRecipes(group[1])
+		leftCount := CalculateTotalCompleteRecipes(group[0], recipeMap)
+		rightCount := CalculateTotalCompleteRecipes(group[1], recipeMap)
 		if leftCount > 0 && rightCount > 0 {
 			total += leftCount * rightCount
 		}
@@ -112,7 +130,7 @@ func CalculateTotalCompleteRecipes(root *RecipeTreeNode) int {
 	return total
 }
 
-func IsCompleteRecipe(recipe Recipe) bool {
+func IsCompleteRecipe(recipe Recipe, recipeMap map[string]Recipe) bool {
 	if len(recipe.Recipes) == 0 {
 		return false
 	}
@@ -120,7 +138,7 @@ func IsCompleteRecipe(recipe Recipe) bool {
 		if len(r) != 2 {
 			return false
 		}
-		if !IsBaseElement(r[0]) || !IsBaseElement(r[1]) {
+		if !IsBaseElement(r[0], recipeMap) || !IsBaseElement(r[1], recipeMap) {
 			return false
 		}
 	}
@@ -131,15 +149,19 @@ func BuildRecipeTreeDFS(
 	root *RecipeTreeNode,
 	recipeMap map[string]Recipe,
 	maxRecipes int,
+	maxDepth int,
 	stopChan chan bool,
 	wg *sync.WaitGroup,
 	mu *sync.Mutex,
 	nodesVisited *int,
 	treeChan chan *RecipeTreeNode,
-	streaming bool,
+	pace *Pace,
+	logger *slog.Logger,
 ) {
 	defer wg.Done()
 
+	reachedMax := false
+	depths := map[*RecipeTreeNode]int{root: 0}
 	stack := []*RecipeTreeNode{root}
 	for len(stack) > 0 {
 		select {
@@ -149,12 +171,15 @@ func BuildRecipeTreeDFS(
 		}
 		node := stack[len(stack)-1]
 		stack = stack[:len(stack)-1]
-		log.Printf("Visiting node: %s\n", node.Name)
+		traceNode(logger, "DFS", node, depths[node])
 
 		recipe, exists := recipeMap[node.Name]
 		if !exists {
 			return
 		}
+		if maxDepth > 0 && depths[node] >= maxDepth {
+			continue
+		}
 
 		var children [][]*RecipeTreeNode
 		var childWg sync.WaitGroup
@@ -171,18 +196,22 @@ func BuildRecipeTreeDFS(
 					childNodes = append(childNodes, childNode)
 
 					mu.Lock()
+					depths[childNode] = depths[node] + 1
 					stack = append(stack, childNode)
 					mu.Unlock()
 				}
 
-				if len(r) == 2 && IsBaseElement(r[0]) && IsBaseElement(r[1]) {
+				if len(r) == 2 && IsBaseElement(r[0], recipeMap) && IsBaseElement(r[1], recipeMap) {
 					mu.Lock()
-					treeChan <- root
-					if streaming {
-						time.Sleep(500 * time.Millisecond)
+					if reachedMax {
+						mu.Unlock()
+						return
 					}
-					if CalculateTotalCompleteRecipes(root) >= maxRecipes {
-						stopChan <- true
+					notifyTree(treeChan, root)
+					pace.Wait(stopChan)
+					if CalculateTotalCompleteRecipes(root, recipeMap) >= maxRecipes {
+						reachedMax = true
+						mu.Unlock()
 						return
 					}
 					mu.Unlock()
@@ -196,6 +225,9 @@ func BuildRecipeTreeDFS(
 		}
 
 		childWg.Wait()
+		if reachedMax {
+			return
+		}
 
 		mu.Lock()
 		SetChildren(node, children)
@@ -212,19 +244,45 @@ func BuildRecipeTreeDFS(
 	}
 }
 
+// notifyTree tells the reader of treeChan that the tree changed. It never
+// blocks, since the builder holds its lock here: when an update is already
+// waiting, the reader will see this change too.
+func notifyTree(treeChan chan *RecipeTreeNode, root *RecipeTreeNode) {
+	select {
+	case treeChan <- root:
+	default:
+	}
+}
+
+// traceNode logs a node a search visits to logger, or to the default logger
+// when it is nil. It checks the level first, since it runs once per node and
+// debug logging is usually off.
+func traceNode(logger *slog.Logger, method string, node *RecipeTreeNode, depth int) {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	if logger.Enabled(context.Background(), slog.LevelDebug) {
+		logger.Debug("visiting node", "method", method, "element", node.Name, "depth", depth)
+	}
+}
+
 func BuildRecipeTreeBFS(
 	root *RecipeTreeNode,
 	recipeMap map[string]Recipe,
 	maxRecipes int,
+	maxDepth int,
 	stopChan chan bool,
 	wg *sync.WaitGroup,
 	mu *sync.Mutex,
 	nodesVisited *int,
 	treeChan chan *RecipeTreeNode,
-	streaming bool,
+	pace *Pace,
+	logger *slog.Logger,
 ) {
 	defer wg.Done()
 
+	reachedMax := false
+	depths := map[*RecipeTreeNode]int{root: 0}
 	queue := []*RecipeTreeNode{root}
 	for len(queue) > 0 {
 		select {
@@ -235,11 +293,15 @@ func BuildRecipeTreeBFS(
 
 		node := queue[0]
 		queue = queue[1:]
+		traceNode(logger, "BFS", node, depths[node])
 
 		recipe, exists := recipeMap[node.Name]
 		if !exists {
 			return
 		}
+		if maxDepth > 0 && depths[node] >= maxDepth {
+			continue
+		}
 
 		var children [][]*RecipeTreeNode
 		var childWg sync.WaitGroup
@@ -256,14 +318,16 @@ func BuildRecipeTreeBFS(
 					childNodes = append(childNodes, childNode)
 
 					mu.Lock()
+					depths[childNode] = depths[node] + 1
 					queue = append(queue, childNode)
 					mu.Unlock()
 				}
 
-				if len(r) == 2 && IsBaseElement(r[0]) && IsBaseElement(r[1]) {
+				if len(r) == 2 && IsBaseElement(r[0], recipeMap) && IsBaseElement(r[1], recipeMap) {
 					mu.Lock()
-					if CalculateTotalCompleteRecipes(root) >= maxRecipes {
-						stopChan <- true
+					if reachedMax || CalculateTotalCompleteRecipes(root, recipeMap) >= maxRecipes {
+						reachedMax = true
+						mu.Unlock()
 						return
 					}
 					mu.Unlock()
@@ -276,6 +340,9 @@ func BuildRecipeTreeBFS(
 			}(r)
 		}
 		childWg.Wait()
+		if reachedMax {
+			return
+		}
 
 		mu.Lock()
 		SetChildren(node, children)
@@ -289,19 +356,88 @@ func BuildRecipeTreeBFS(
 		mu.Lock()
 		*nodesVisited++
 		if *nodesVisited%6 == 0 {
-			treeChan <- root
-			if streaming {
-				time.Sleep(500 * time.Millisecond)
-			}
+			notifyTree(treeChan, root)
+			pace.Wait(stopChan)
 		}
 		mu.Unlock()
 	}
 }
 
-func StopSearch(stopChan chan bool, wg *sync.WaitGroup) {
-	defer wg.Done()
-	<-stopChan
-	fmt.Println("Stopping the search!")
+// Pace throttles a streaming search after every update it sends on its tree
+// channel, and lets it be paused and resumed. A nil *Pace never waits.
+type Pace struct {
+	mu     sync.Mutex
+	delay  time.Duration
+	resume chan struct{}
+}
+
+func NewPace(delay time.Duration) *Pace {
+	return &Pace{delay: delay}
+}
+
+func (p *Pace) SetDelay(delay time.Duration) {
+	p.mu.Lock()
+	p.delay = delay
+	p.mu.Unlock()
+}
+
+func (p *Pace) Pause() {
+	p.mu.Lock()
+	if p.resume == nil {
+		p.resume = make(chan struct{})
+	}
+	p.mu.Unlock()
+}
+
+func (p *Pace) Resume() {
+	p.mu.Lock()
+	if p.resume != nil {
+		close(p.resume)
+		p.resume = nil
+	}
+	p.mu.Unlock()
+}
+
+func (p *Pace) Paused() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.resume != nil
+}
+
+// Wait blocks while the search is paused, then for the current delay. It
+// returns early when stopChan is closed.
+func (p *Pace) Wait(stopChan chan bool) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	delay, resume := p.delay, p.resume
+	p.mu.Unlock()
+
+	if resume != nil {
+		select {
+		case <-resume:
+		case <-stopChan:
+			return
+		}
+	}
+	if delay <= 0 {
+		return
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-stopChan:
+	}
+}
+
+// StopSearch stops a running BuildRecipeTree* call by closing its stop
+// channel. It is safe to call more than once through the same once.
+func StopSearch(stopChan chan bool, once *sync.Once) {
+	once.Do(func() {
+		close(stopChan)
+	})
 }
 
 func SetChildren(node *RecipeTreeNode, children [][]*RecipeTreeNode) {
@@ -339,14 +475,14 @@ func HasBaseElements(node *RecipeTreeNode) bool {
 	return false
 }
 
-func PruneTree(node *RecipeTreeNode) {
+func PruneTree(node *RecipeTreeNode, recipeMap map[string]Recipe) {
 	var newChildren [][]*RecipeTreeNode
 	for _, recipe := range node.Children {
 		bothBase := true
 		for _, child := range recipe {
-			if CalculateTotalCompleteRecipes(child) == 0 {
+			if CalculateTotalCompleteRecipes(child, recipeMap) == 0 {
 				bothBase = false
-				fmt.Println("Pruning", child.Name)
+				slog.Debug("pruning", "element", child.Name)
 				break
 			}
 		}
@@ -357,7 +493,7 @@ func PruneTree(node *RecipeTreeNode) {
 	SetChildren(node, newChildren)
 	for _, recipe := range newChildren {
 		for _, child := range recipe {
-			PruneTree(child)
+			PruneTree(child, recipeMap)
 		}
 	}
 }
@@ -388,7 +524,7 @@ func BuildRecipeTreeBidirectional(
 			continue
 		}
 
-		if IsBaseElement(recipeName) {
+		if IsBaseElement(recipeName, recipeMap) {
 			owned[recipeName] = true
 			canMake := GetCreatedBy(recipeName)
 			for _, r := range canMake {
@@ -429,7 +565,7 @@ func BuildRecipeTreeBidirectional(
 
 		recipe, exists := recipeMap[nodebfs.Name]
 		if !exists {
-			fmt.Println("Recipe not found:", nodebfs.Name)
+			slog.Warn("recipe not found", "element", nodebfs.Name)
 			continue
 		}
 
@@ -444,8 +580,8 @@ func BuildRecipeTreeBidirectional(
 				bfsVisited[name] = childNode
 			}
 
-			if len(r) == 2 && IsBaseElement(r[0]) && IsBaseElement(r[1]) {
-				if CalculateTotalCompleteRecipes(root) >= maxRecipes {
+			if len(r) == 2 && IsBaseElement(r[0], recipeMap) && IsBaseElement(r[1], recipeMap) {
+				if CalculateTotalCompleteRecipes(root, recipeMap) >= maxRecipes {
 					return
 				}
 			}
@@ -455,7 +591,7 @@ func BuildRecipeTreeBidirectional(
 		SetChildren(nodebfs, children)
 		*nodesVisited++
 		synchronizeRecipeTree(bfsVisited, recipeToTree)
-		if CalculateTotalCompleteRecipes(root) >= maxRecipes {
+		if CalculateTotalCompleteRecipes(root, recipeMap) >= maxRecipes {
 			return
 		}
 	}
@@ -483,7 +619,7 @@ func BuildFromBottom(
 			continue
 		}
 
-		if IsBaseElement(recipeName) {
+		if IsBaseElement(recipeName, recipeMap) {
 			owned[recipeName] = true
 			canMake := GetCreatedBy(recipeName)
 			for _, r := range canMake {
@@ -0,0 +1,146 @@
+package scraper
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gocolly/colly"
+)
+
+// crawler hands out collectors sharing the same politeness settings and
+// keeps track of every request they make, so a run can tell whether it
+// scraped everything or only part of the wiki.
+type crawler struct {
+	opts Options
+
+	mu        sync.Mutex
+	requests  int
+	retries   int
+	requested map[string]bool
+	failed    map[string]string
+}
+
+func newCrawler(opts Options) *crawler {
+	return &crawler{
+		opts:      opts,
+		requested: make(map[string]bool),
+		failed:    make(map[string]string),
+	}
+}
+
+func (cr *crawler) collector(async bool) *colly.Collector {
+	c := colly.NewCollector(colly.Async(async))
+	if cr.opts.UserAgent != "" {
+		c.UserAgent = cr.opts.UserAgent
+	}
+	if cr.opts.CacheDir != "" {
+		c.CacheDir = cr.opts.CacheDir
+	}
+	if cr.opts.Timeout > 0 {
+		c.SetRequestTimeout(cr.opts.Timeout)
+	}
+	parallelism := cr.opts.Parallelism
+	if parallelism < 1 {
+		parallelism = 1
+	}
+	if err := c.Limit(&colly.LimitRule{DomainGlob: "*", Delay: cr.opts.Delay, Parallelism: parallelism}); err != nil {
+		log.Printf("Ignoring rate limit: %v", err)
+	}
+
+	c.OnRequest(func(r *colly.Request) {
+		cr.mu.Lock()
+		if r.Ctx.GetAny("attempt") == nil {
+			cr.requests++
+		}
+		cr.requested[r.URL.String()] = true
+		cr.mu.Unlock()
+	})
+	c.OnError(cr.onError)
+	return c
+}
+
+func (cr *crawler) onError(r *colly.Response, err error) {
+	url := r.Request.URL.String()
+	attempt, _ := r.Ctx.GetAny("attempt").(int)
+
+	if attempt < cr.opts.Retries {
+		wait := cr.opts.Backoff << attempt
+		log.Printf("Request to %s failed (%v); retry %d/%d in %s", url, err, attempt+1, cr.opts.Retries, wait)
+		time.Sleep(wait)
+
+		cr.mu.Lock()
+		cr.retries++
+		cr.mu.Unlock()
+
+		// A failed retry comes back through onError with the next attempt.
+		r.Ctx.Put("attempt", attempt+1)
+		r.Request.Retry()
+		return
+	}
+
+	log.Printf("Request to %s failed: %v", url, err)
+	cr.mu.Lock()
+	cr.failed[url] = err.Error()
+	cr.mu.Unlock()
+}
+
+// visit requests url, recording a failure for errors that happen before the
+// request ever reaches OnError (bad URLs, robots.txt, ...).
+func (cr *crawler) visit(c *colly.Collector, url string, ctx *colly.Context) {
+	if ctx == nil {
+		ctx = colly.NewContext()
+	}
+	err := c.Request("GET", url, nil, ctx, nil)
+	if err == nil {
+		return
+	}
+
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	if !cr.requested[url] {
+		cr.requests++
+		cr.failed[url] = err.Error()
+	}
+}
+
+// get fetches url and returns its body.
+func (cr *crawler) get(url string) ([]byte, error) {
+	var body []byte
+	c := cr.collector(false)
+	c.OnResponse(func(r *colly.Response) {
+		body = r.Body
+	})
+	cr.visit(c, url, nil)
+
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	if reason, failed := cr.failed[url]; failed {
+		return nil, fmt.Errorf("failed to fetch %s: %s", url, reason)
+	}
+	return body, nil
+}
+
+// summary logs how the run went and returns an error if any URL could not
+// be fetched.
+func (cr *crawler) summary() error {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+
+	log.Printf("Scraped %d pages (%d retries, %d failed)", cr.requests, cr.retries, len(cr.failed))
+	if len(cr.failed) == 0 {
+		return nil
+	}
+
+	urls := make([]string, 0, len(cr.failed))
+	for url := range cr.failed {
+		urls = append(urls, url)
+	}
+	sort.Strings(urls)
+	for _, url := range urls {
+		log.Printf("  failed: %s (%s)", url, cr.failed[url])
+	}
+	return fmt.Errorf("%d of %d requests failed", len(cr.failed), cr.requests)
+}
@@ -0,0 +1,73 @@
+package scraper
+
+import (
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/gocolly/colly"
+)
+
+// scrapeDetails visits the wiki page of every element that has a PageURL and
+// fills in its description, "used in" list, categories and pack in place.
+func (cr *crawler) scrapeDetails(elements []ElementWithRecipes) {
+	c := cr.collector(true)
+
+	c.OnHTML("div.mw-parser-output", func(e *colly.HTMLElement) {
+		el := &elements[e.Request.Ctx.GetAny("index").(int)]
+
+		e.DOM.Children().Filter("p").EachWithBreak(func(_ int, p *goquery.Selection) bool {
+			text := strings.TrimSpace(p.Text())
+			if text == "" {
+				return true
+			}
+			el.Description = text
+			return false
+		})
+
+		if pack := strings.TrimSpace(e.ChildText("div.pi-item[data-source='pack'] .pi-data-value")); pack != "" {
+			el.Pack = pack
+		}
+
+		e.DOM.Find("h2, h3").Each(func(_ int, h *goquery.Selection) {
+			if !strings.EqualFold(strings.TrimSpace(h.Find("span.mw-headline").Text()), "Used in") {
+				return
+			}
+			h.NextUntil("h2, h3").Find("a").Each(func(_ int, a *goquery.Selection) {
+				if a.HasClass("image") {
+					return
+				}
+				name := strings.TrimSpace(a.Text())
+				if name != "" && !contains(el.UsedIn, name) {
+					el.UsedIn = append(el.UsedIn, name)
+				}
+			})
+		})
+	})
+
+	c.OnHTML("div.page-header__categories a[href*='/wiki/Category:']", func(e *colly.HTMLElement) {
+		el := &elements[e.Request.Ctx.GetAny("index").(int)]
+		name := strings.TrimSpace(e.Text)
+		if name != "" && !contains(el.Categories, name) {
+			el.Categories = append(el.Categories, name)
+		}
+	})
+
+	for i, el := range elements {
+		if el.PageURL == "" {
+			continue
+		}
+		ctx := colly.NewContext()
+		ctx.Put("index", i)
+		cr.visit(c, el.PageURL, ctx)
+	}
+	c.Wait()
+}
+
+func contains(slice []string, item string) bool {
+	for _, v := range slice {
+		if v == item {
+			return true
+		}
+	}
+	return false
+}
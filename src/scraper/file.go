@@ -0,0 +1,158 @@
+package scraper
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// FileSource reads a user-provided recipe list from a local path or an
+// http(s) URL. JSON files use the recipes.json layout; CSV files need a
+// header row naming at least an "element" column, plus "left" and "right"
+// ingredient columns and optionally "tier" and "image_url". Each CSV row is
+// one recipe, and rows without ingredients mark base elements. Tiers are
+// computed from the recipes when the file doesn't carry them.
+type FileSource struct {
+	Dataset string
+	Path    string
+}
+
+func (s *FileSource) Name() string {
+	return s.Dataset
+}
+
+func (s *FileSource) Fetch(cr *crawler) ([]byte, error) {
+	if strings.HasPrefix(s.Path, "http://") || strings.HasPrefix(s.Path, "https://") {
+		return cr.get(s.Path)
+	}
+	return os.ReadFile(s.Path)
+}
+
+func (s *FileSource) Parse(data []byte) ([]ElementWithRecipes, []RejectedRow, error) {
+	if strings.EqualFold(path.Ext(s.Path), ".csv") {
+		return parseCSV(data)
+	}
+	return parseJSON(data)
+}
+
+func parseJSON(data []byte) ([]ElementWithRecipes, []RejectedRow, error) {
+	var raw []ElementWithRecipes
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, nil, fmt.Errorf("error decoding JSON: %w", err)
+	}
+
+	var elements []ElementWithRecipes
+	var rejects []RejectedRow
+	hasTiers := false
+	for _, el := range raw {
+		el.Element = strings.TrimSpace(el.Element)
+		if el.Element == "" {
+			rejects = append(rejects, RejectedRow{Tier: el.Tier, Reason: ReasonMissingName})
+			continue
+		}
+		var recipes [][]string
+		for _, r := range el.Recipes {
+			if len(r) != 0 && len(r) != 2 {
+				rejects = append(rejects, RejectedRow{Element: el.Element, Tier: el.Tier, Reason: ReasonWrongArity, Detail: strings.Join(r, " + ")})
+				continue
+			}
+			recipes = append(recipes, r)
+		}
+		el.Recipes = recipes
+		elements = append(elements, el)
+		hasTiers = hasTiers || el.Tier != 0
+	}
+
+	if hasTiers {
+		return elements, rejects, nil
+	}
+	elements, unreachable := assignTiers(elements)
+	return elements, append(rejects, unreachable...), nil
+}
+
+func parseCSV(data []byte) ([]ElementWithRecipes, []RejectedRow, error) {
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, nil, fmt.Errorf("error reading CSV header: %w", err)
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	if _, ok := columns["element"]; !ok {
+		return nil, nil, fmt.Errorf("CSV header has no element column")
+	}
+	field := func(row []string, name string) string {
+		i, ok := columns[name]
+		if !ok || i >= len(row) {
+			return ""
+		}
+		return strings.TrimSpace(row[i])
+	}
+	_, hasTiers := columns["tier"]
+
+	var elements []ElementWithRecipes
+	var rejects []RejectedRow
+	index := make(map[string]int)
+	for line := 2; ; line++ {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("error reading CSV line %d: %w", line, err)
+		}
+		raw := strings.Join(row, ",")
+
+		name := field(row, "element")
+		if name == "" {
+			rejects = append(rejects, RejectedRow{Reason: ReasonMissingName, HTML: raw})
+			continue
+		}
+		tier := 0
+		if hasTiers {
+			if tier, err = strconv.Atoi(field(row, "tier")); err != nil {
+				rejects = append(rejects, RejectedRow{Element: name, Reason: ReasonBadTier, Detail: field(row, "tier"), HTML: raw})
+				continue
+			}
+		}
+
+		i, ok := index[name]
+		if !ok {
+			i = len(elements)
+			index[name] = i
+			elements = append(elements, ElementWithRecipes{
+				Element:  name,
+				Tier:     tier,
+				ImageURL: field(row, "image_url"),
+				Recipes:  [][]string{},
+			})
+		}
+
+		left, right := field(row, "left"), field(row, "right")
+		switch {
+		case left == "" && right == "":
+			elements[i].Recipes = append(elements[i].Recipes, []string{})
+		case left == "" || right == "":
+			rejects = append(rejects, RejectedRow{Element: name, Tier: tier, Reason: ReasonWrongArity, HTML: raw})
+		default:
+			elements[i].Recipes = append(elements[i].Recipes, []string{left, right})
+		}
+	}
+
+	if hasTiers {
+		return elements, rejects, nil
+	}
+	elements, unreachable := assignTiers(elements)
+	return elements, append(rejects, unreachable...), nil
+}
@@ -0,0 +1,63 @@
+package scraper
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gocolly/colly"
+)
+
+var imageExtensions = map[string]string{
+	"image/svg+xml": ".svg",
+	"image/png":     ".png",
+	"image/jpeg":    ".jpg",
+	"image/gif":     ".gif",
+	"image/webp":    ".webp",
+}
+
+// mirrorImages downloads the icon of every element into dir, naming each
+// file after the hash of its content, and records the file name in
+// ImageFile.
+func (cr *crawler) mirrorImages(elements []ElementWithRecipes, dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	c := cr.collector(true)
+	c.OnResponse(func(r *colly.Response) {
+		el := &elements[r.Ctx.GetAny("index").(int)]
+
+		mediaType, _, _ := mime.ParseMediaType(r.Headers.Get("Content-Type"))
+		ext, ok := imageExtensions[mediaType]
+		if !ok {
+			ext = strings.ToLower(filepath.Ext(r.Request.URL.Path))
+		}
+
+		sum := sha256.Sum256(r.Body)
+		name := hex.EncodeToString(sum[:8]) + ext
+		path := filepath.Join(dir, name)
+		if _, err := os.Stat(path); err != nil {
+			if err := os.WriteFile(path, r.Body, 0644); err != nil {
+				log.Printf("Failed to save image of %s: %v", el.Element, err)
+				return
+			}
+		}
+		el.ImageFile = name
+	})
+
+	for i, el := range elements {
+		if !strings.HasPrefix(el.ImageURL, "http") {
+			continue
+		}
+		ctx := colly.NewContext()
+		ctx.Put("index", i)
+		cr.visit(c, el.ImageURL, ctx)
+	}
+	c.Wait()
+	return nil
+}
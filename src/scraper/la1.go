@@ -0,0 +1,80 @@
+package scraper
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// LittleAlchemy1 scrapes the element list of the original Little Alchemy.
+// The wiki doesn't group that list by tier, so tiers are computed from the
+// recipes.
+type LittleAlchemy1 struct{}
+
+func (s *LittleAlchemy1) Name() string {
+	return "la1"
+}
+
+func (s *LittleAlchemy1) Fetch(cr *crawler) ([]byte, error) {
+	return cr.get(wikiURL + "/wiki/Elements_(Little_Alchemy)")
+}
+
+func (s *LittleAlchemy1) Parse(data []byte) ([]ElementWithRecipes, []RejectedRow, error) {
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var elements []ElementWithRecipes
+	var rejects []RejectedRow
+	seen := make(map[string]bool)
+
+	doc.Find("table.list-table tbody tr").Each(func(_ int, row *goquery.Selection) {
+		if row.Find("td").Length() == 0 {
+			return
+		}
+		name := strings.TrimSpace(row.Find("td:nth-of-type(1) a").Text())
+		if name == "" {
+			rejects = append(rejects, RejectedRow{Reason: ReasonMissingName, HTML: rawHTML(row)})
+			return
+		}
+		if seen[name] {
+			return
+		}
+		seen[name] = true
+
+		imgURL, _ := row.Find("td:nth-of-type(1) a").Attr("href")
+		if imgURL == "" {
+			imgURL = "No image"
+		}
+
+		recipes := [][]string{}
+		if baseElements[name] {
+			recipes = [][]string{{}}
+		} else {
+			row.Find("td:nth-of-type(2) li").Each(func(_ int, li *goquery.Selection) {
+				comps, reason, detail := classifyRecipe(li, nil)
+				if reason != "" {
+					rejects = append(rejects, RejectedRow{Element: name, Reason: reason, Detail: detail, HTML: rawHTML(li)})
+					return
+				}
+				recipes = append(recipes, comps)
+			})
+			if len(recipes) == 0 {
+				rejects = append(rejects, RejectedRow{Element: name, Reason: ReasonNoRecipes, HTML: rawHTML(row)})
+				return
+			}
+		}
+
+		elements = append(elements, ElementWithRecipes{
+			Element:  name,
+			ImageURL: imgURL,
+			Recipes:  recipes,
+			PageURL:  pageURL(row),
+		})
+	})
+
+	elements, unreachable := assignTiers(elements)
+	return elements, append(rejects, unreachable...), nil
+}
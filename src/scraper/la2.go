@@ -0,0 +1,155 @@
+package scraper
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/gocolly/colly"
+)
+
+const wikiURL = "https://little-alchemy.fandom.com"
+
+// LittleAlchemy2 scrapes the Little Alchemy 2 element list of the fandom
+// wiki, leaving out the Myths and Monsters pack.
+type LittleAlchemy2 struct {
+	excluded map[string]bool
+}
+
+func (s *LittleAlchemy2) Name() string {
+	return "la2"
+}
+
+func (s *LittleAlchemy2) Fetch(cr *crawler) ([]byte, error) {
+	s.excluded = cr.excludedElements()
+	return cr.get(wikiURL + "/wiki/Elements_(Little_Alchemy_2)")
+}
+
+func (cr *crawler) excludedElements() map[string]bool {
+	excluded := make(map[string]bool)
+	c := cr.collector(false)
+	c.OnHTML("li.category-page__member", func(e *colly.HTMLElement) {
+		name := strings.TrimSpace(e.ChildText("a.category-page__member-link"))
+		if name != "" {
+			excluded[name] = true
+		}
+	})
+	cr.visit(c, wikiURL+"/wiki/Category:Myths_and_Monsters", nil)
+	excluded["Time"] = true
+	excluded["Ruins"] = true
+	return excluded
+}
+
+func (s *LittleAlchemy2) Parse(data []byte) ([]ElementWithRecipes, []RejectedRow, error) {
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, err
+	}
+	excluded := s.excluded
+	if excluded == nil {
+		excluded = make(map[string]bool)
+	}
+	baseEls := map[string]bool{"Fire": true, "Earth": true, "Water": true, "Air": true}
+
+	var elements []ElementWithRecipes
+	var rejects []RejectedRow
+
+	doc.Find("h3").Each(func(_ int, h *goquery.Selection) {
+		headline := h.Find("span.mw-headline").Text()
+
+		if headline == "Starting elements" {
+			tableSel := h.NextUntil("h3").FilterFunction(func(_ int, s *goquery.Selection) bool {
+				return s.Is("table.list-table.col-list.icon-hover")
+			}).First()
+
+			tableSel.Find("tbody tr").Each(func(_ int, row *goquery.Selection) {
+				name := strings.TrimSpace(row.Find("td:nth-of-type(1) a").Text())
+				if name == "" || excluded[name] || !baseEls[name] {
+					return
+				}
+				imgURL, _ := row.Find("td:nth-of-type(1) a").Attr("href")
+				if imgURL == "" {
+					imgURL = "No image"
+				}
+
+				elements = append(elements, ElementWithRecipes{
+					Element:  name,
+					Tier:     0,
+					ImageURL: imgURL,
+					Recipes:  [][]string{{}},
+					PageURL:  pageURL(row),
+				})
+			})
+			return
+		}
+
+		if !strings.HasPrefix(headline, "Tier ") {
+			return
+		}
+		parts := strings.Fields(headline)
+		tier, err := strconv.Atoi(parts[1])
+		if err != nil {
+			return
+		}
+
+		tableSel := h.NextUntil("h3").FilterFunction(func(_ int, s *goquery.Selection) bool {
+			return s.Is("table.list-table.col-list.icon-hover")
+		}).First()
+
+		tableSel.Find("tbody tr").Each(func(_ int, row *goquery.Selection) {
+			name := strings.TrimSpace(row.Find("td:nth-of-type(1) a").Text())
+			if name == "" {
+				if row.Find("td").Length() > 0 {
+					rejects = append(rejects, RejectedRow{Tier: tier, Reason: ReasonMissingName, HTML: rawHTML(row)})
+				}
+				return
+			}
+			if excluded[name] {
+				rejects = append(rejects, RejectedRow{Element: name, Tier: tier, Reason: ReasonExcludedElement, HTML: rawHTML(row)})
+				return
+			}
+			if baseEls[name] {
+				return
+			}
+			imgURL, _ := row.Find("td:nth-of-type(1) a").Attr("href")
+			if imgURL == "" {
+				imgURL = "No image"
+			}
+
+			var recipes [][]string
+			row.Find("td:nth-of-type(2) li").Each(func(_ int, li *goquery.Selection) {
+				comps, reason, detail := classifyRecipe(li, excluded)
+				if reason != "" {
+					rejects = append(rejects, RejectedRow{
+						Element: name,
+						Tier:    tier,
+						Reason:  reason,
+						Detail:  detail,
+						HTML:    rawHTML(li),
+					})
+					return
+				}
+				recipes = append(recipes, comps)
+			})
+
+			elements = append(elements, ElementWithRecipes{
+				Element:  name,
+				Tier:     tier,
+				ImageURL: imgURL,
+				Recipes:  recipes,
+				PageURL:  pageURL(row),
+			})
+		})
+	})
+
+	return elements, rejects, nil
+}
+
+func pageURL(row *goquery.Selection) string {
+	href, _ := row.Find("td:nth-of-type(1) a[href^='/wiki/']").First().Attr("href")
+	if href == "" {
+		return ""
+	}
+	return wikiURL + href
+}
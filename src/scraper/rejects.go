@@ -0,0 +1,87 @@
+package scraper
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+const (
+	ReasonMissingName        = "missing name"
+	ReasonExcludedElement    = "excluded element"
+	ReasonExcludedIngredient = "excluded ingredient"
+	ReasonWrongArity         = "wrong arity"
+	ReasonMissingLink        = "missing link"
+	ReasonNoRecipes          = "no recipes"
+	ReasonUnreachable        = "unreachable"
+	ReasonBadTier            = "bad tier"
+)
+
+// RejectedRow is a table row or recipe item that FindRecipes dropped, kept
+// together with its raw HTML so markup changes can be told apart from our
+// own filters.
+type RejectedRow struct {
+	Element string `json:"element,omitempty"`
+	Tier    int    `json:"tier"`
+	Reason  string `json:"reason"`
+	Detail  string `json:"detail,omitempty"`
+	HTML    string `json:"html"`
+}
+
+func rawHTML(s *goquery.Selection) string {
+	html, err := goquery.OuterHtml(s)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(html)
+}
+
+// classifyRecipe returns the two ingredients of a recipe list item, or the
+// reason and detail explaining why the item cannot be used.
+func classifyRecipe(li *goquery.Selection, excluded map[string]bool) ([]string, string, string) {
+	var comps, dropped []string
+	anchors := 0
+	li.Find("a").Each(func(_ int, a *goquery.Selection) {
+		t := strings.TrimSpace(a.Text())
+		if t == "" {
+			return
+		}
+		anchors++
+		if excluded[t] {
+			dropped = append(dropped, t)
+			return
+		}
+		comps = append(comps, t)
+	})
+
+	if len(comps) == 2 {
+		return comps, "", ""
+	}
+	if len(dropped) > 0 {
+		return nil, ReasonExcludedIngredient, strings.Join(dropped, ", ")
+	}
+
+	parts := 0
+	for _, p := range strings.Split(li.Text(), "+") {
+		if strings.TrimSpace(p) != "" {
+			parts++
+		}
+	}
+	if anchors < parts {
+		return nil, ReasonMissingLink, strings.TrimSpace(li.Text())
+	}
+	return nil, ReasonWrongArity, strings.TrimSpace(li.Text())
+}
+
+func writeRejects(path string, rejects []RejectedRow) error {
+	if rejects == nil {
+		rejects = []RejectedRow{}
+	}
+	out, err := json.MarshalIndent(rejects, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, out, 0644)
+}
@@ -2,130 +2,123 @@ package scraper
 
 import (
 	"encoding/json"
+	"fmt"
 	"log"
 	"os"
-	"strconv"
+	"path/filepath"
 	"strings"
+	"time"
 
-	"github.com/PuerkitoBio/goquery"
-	"github.com/gocolly/colly"
+	recipe "github.com/Henshou/Tubes2_BE_CraftingTable.git/recipe"
 )
 
 type ElementWithRecipes struct {
-	Element  string     `json:"element"`
-	Tier     int        `json:"tier"`
-	ImageURL string     `json:"image_url"`
-	Recipes  [][]string `json:"recipes"`
+	Element     string     `json:"element"`
+	Tier        int        `json:"tier"`
+	ImageURL    string     `json:"image_url"`
+	ImageFile   string     `json:"image_file,omitempty"`
+	Recipes     [][]string `json:"recipes"`
+	PageURL     string     `json:"page_url,omitempty"`
+	Description string     `json:"description,omitempty"`
+	UsedIn      []string   `json:"used_in,omitempty"`
+	Categories  []string   `json:"categories,omitempty"`
+	Pack        string     `json:"pack,omitempty"`
 }
 
-func ExcludedElements() map[string]bool {
-	excluded := make(map[string]bool)
-	c := colly.NewCollector()
-	c.OnHTML("li.category-page__member", func(e *colly.HTMLElement) {
-		name := strings.TrimSpace(e.ChildText("a.category-page__member-link"))
-		if name != "" {
-			excluded[name] = true
-		}
-	})
-	if err := c.Visit("https://little-alchemy.fandom.com/wiki/Category:Myths_and_Monsters"); err != nil {
-		log.Fatal(err)
-	}
-	excluded["Time"] = true
-	excluded["Ruins"] = true
-	return excluded
+type Options struct {
+	// Details makes the scraper visit every element's own wiki page to
+	// collect its description, "used in" list, categories and pack.
+	Details bool
+	// OutputDir is where the dataset file is written.
+	OutputDir string
+	// RejectsPath is where the report of dropped rows is written, relative
+	// to OutputDir unless it is absolute. Defaults to rejected.json.
+	RejectsPath string
+	// ImageDir, when set, is where element icons are mirrored to, relative
+	// to OutputDir unless it is absolute.
+	ImageDir string
+
+	UserAgent string
+	// CacheDir, when set, keeps every HTTP response on disk so repeated runs
+	// don't hit the wiki again.
+	CacheDir string
+	Timeout  time.Duration
+	// Delay is the pause between two requests to the same domain.
+	Delay       time.Duration
+	Parallelism int
+	// Retries is how many times a failed request is tried again, waiting
+	// Backoff, then twice as long, and so on.
+	Retries int
+	Backoff time.Duration
 }
 
-func FindRecipes() {
-	excluded := ExcludedElements()
-	baseEls := map[string]bool{"Fire": true, "Earth": true, "Water": true, "Air": true}
-
-	var elements []ElementWithRecipes
-
-	c := colly.NewCollector()
-
-	c.OnHTML("h3", func(e *colly.HTMLElement) {
-		headline := e.ChildText("span.mw-headline")
-
-		if headline == "Starting elements" {
-			tableSel := e.DOM.NextUntil("h3").FilterFunction(func(_ int, s *goquery.Selection) bool {
-				return s.Is("table.list-table.col-list.icon-hover")
-			}).First()
+func DefaultOptions() Options {
+	return Options{
+		RejectsPath: "rejected.json",
+		UserAgent:   "CraftingTable-Scraper/1.0 (+https://github.com/Henshou/Tubes2_BE_CraftingTable)",
+		Timeout:     30 * time.Second,
+		Delay:       200 * time.Millisecond,
+		Parallelism: 4,
+		Retries:     3,
+		Backoff:     time.Second,
+	}
+}
 
-			tableSel.Find("tbody tr").Each(func(_ int, row *goquery.Selection) {
-				name := strings.TrimSpace(row.Find("td:nth-of-type(1) a").Text())
-				if name == "" || excluded[name] || !baseEls[name] {
-					return
-				}
-				imgURL, _ := row.Find("td:nth-of-type(1) a").Attr("href")
-				if imgURL == "" {
-					imgURL = "No image"
-				}
+// FindRecipes fetches and parses src and writes the resulting dataset to
+// recipe.DatasetFile(src.Name()) in opts.OutputDir. Nothing is written if any
+// request failed.
+func FindRecipes(src Source, opts Options) error {
+	cr := newCrawler(opts)
+	file := filepath.Join(opts.OutputDir, recipe.DatasetFile(src.Name()))
 
-				elements = append(elements, ElementWithRecipes{
-					Element:  name,
-					Tier:     0,
-					ImageURL: imgURL,
-					Recipes:  [][]string{{}},
-				})
-			})
-			return
-		}
+	data, err := src.Fetch(cr)
+	if err != nil {
+		cr.summary()
+		return fmt.Errorf("not writing %s: %w", file, err)
+	}
+	elements, rejects, err := src.Parse(data)
+	if err != nil {
+		return fmt.Errorf("failed to parse %s: %w", src.Name(), err)
+	}
 
-		if !strings.HasPrefix(headline, "Tier ") {
-			return
+	if opts.Details {
+		cr.scrapeDetails(elements)
+	}
+	if opts.ImageDir != "" {
+		imageDir := opts.ImageDir
+		if !filepath.IsAbs(imageDir) {
+			imageDir = filepath.Join(opts.OutputDir, imageDir)
 		}
-		parts := strings.Fields(headline)
-		tier, err := strconv.Atoi(parts[1])
-		if err != nil {
-			return
+		if err := cr.mirrorImages(elements, imageDir); err != nil {
+			return fmt.Errorf("failed to mirror images: %w", err)
 		}
+	}
 
-		tableSel := e.DOM.NextUntil("h3").FilterFunction(func(_ int, s *goquery.Selection) bool {
-			return s.Is("table.list-table.col-list.icon-hover")
-		}).First()
-
-		tableSel.Find("tbody tr").Each(func(_ int, row *goquery.Selection) {
-			name := strings.TrimSpace(row.Find("td:nth-of-type(1) a").Text())
-			if name == "" || excluded[name] || baseEls[name] {
-				return
-			}
-			imgURL, _ := row.Find("td:nth-of-type(1) a").Attr("href")
-			if imgURL == "" {
-				imgURL = "No image"
-			}
-
-			var recipes [][]string
-			row.Find("td:nth-of-type(2) li").Each(func(_ int, li *goquery.Selection) {
-				var comps []string
-				li.Find("a").Each(func(_ int, a *goquery.Selection) {
-					t := strings.TrimSpace(a.Text())
-					if t != "" && !excluded[t] {
-						comps = append(comps, t)
-					}
-				})
-				if len(comps) == 2 {
-					recipes = append(recipes, comps)
-				}
-			})
-
-			elements = append(elements, ElementWithRecipes{
-				Element:  name,
-				Tier:     tier,
-				ImageURL: imgURL,
-				Recipes:  recipes,
-			})
-		})
-	})
-
-	if err := c.Visit("https://little-alchemy.fandom.com/wiki/Elements_(Little_Alchemy_2)"); err != nil {
-		log.Fatal(err)
+	if err := cr.summary(); err != nil {
+		return fmt.Errorf("not writing %s: %w", file, err)
 	}
 
 	out, err := json.MarshalIndent(elements, "", "  ")
 	if err != nil {
-		log.Fatal(err)
+		return err
+	}
+	if err := os.WriteFile(file, out, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", file, err)
+	}
+
+	rejectsPath := opts.RejectsPath
+	if rejectsPath == "" {
+		rejectsPath = "rejected.json"
+	}
+	if !filepath.IsAbs(rejectsPath) {
+		rejectsPath = filepath.Join(opts.OutputDir, rejectsPath)
+	}
+	if src.Name() != recipe.DefaultDataset {
+		rejectsPath = strings.TrimSuffix(rejectsPath, ".json") + "_" + src.Name() + ".json"
 	}
-	if err := os.WriteFile("recipes.json", out, 0644); err != nil {
-		log.Fatal("Failed to write recipes.json:", err)
+	if err := writeRejects(rejectsPath, rejects); err != nil {
+		return fmt.Errorf("failed to write %s: %w", rejectsPath, err)
 	}
+	log.Printf("Wrote %d elements to %s; rejected %d rows, see %s", len(elements), file, len(rejects), rejectsPath)
+	return nil
 }
@@ -0,0 +1,103 @@
+package scraper
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Source is where a dataset of elements and recipes comes from.
+type Source interface {
+	// Name identifies the dataset, e.g. "la2". It is what clients pass as
+	// the dataset parameter.
+	Name() string
+	// Fetch downloads the raw document the elements are parsed from.
+	Fetch(cr *crawler) ([]byte, error)
+	// Parse turns a fetched document into elements, reporting every row it
+	// had to drop.
+	Parse(data []byte) ([]ElementWithRecipes, []RejectedRow, error)
+}
+
+// datasetName is what a dataset may be called. The name ends up in a file
+// name, so it can't hold separators or dots.
+var datasetName = regexp.MustCompile(`^[a-z0-9_-]+$`)
+
+// SourceByName resolves the sources accepted on the command line: "la2",
+// "la1", or "name=path" for a CSV or JSON recipe list at a path or URL.
+func SourceByName(spec string) (Source, error) {
+	switch spec {
+	case "la2":
+		return &LittleAlchemy2{}, nil
+	case "la1":
+		return &LittleAlchemy1{}, nil
+	}
+	name, path, ok := strings.Cut(spec, "=")
+	if !ok || name == "" || path == "" {
+		return nil, fmt.Errorf("unknown source %q", spec)
+	}
+	if !datasetName.MatchString(name) {
+		return nil, fmt.Errorf("dataset name %q may only hold a-z, 0-9, _ and -", name)
+	}
+	return &FileSource{Dataset: name, Path: path}, nil
+}
+
+var baseElements = map[string]bool{"Fire": true, "Earth": true, "Water": true, "Air": true}
+
+// assignTiers computes the tier of every element as one more than the
+// highest tier among the ingredients of its easiest recipe, starting from
+// the elements that have no ingredients at tier 0. Elements that can never
+// be crafted are removed and reported.
+func assignTiers(elements []ElementWithRecipes) ([]ElementWithRecipes, []RejectedRow) {
+	tiers := make(map[string]int)
+	for _, el := range elements {
+		if isBaseRecipes(el.Recipes) {
+			tiers[el.Element] = 0
+		}
+	}
+
+	for changed := true; changed; {
+		changed = false
+		for _, el := range elements {
+			for _, r := range el.Recipes {
+				if len(r) != 2 {
+					continue
+				}
+				left, okLeft := tiers[r[0]]
+				right, okRight := tiers[r[1]]
+				if !okLeft || !okRight {
+					continue
+				}
+				tier := max(left, right) + 1
+				if current, ok := tiers[el.Element]; !ok || tier < current {
+					tiers[el.Element] = tier
+					changed = true
+				}
+			}
+		}
+	}
+
+	var kept []ElementWithRecipes
+	var rejects []RejectedRow
+	for _, el := range elements {
+		tier, ok := tiers[el.Element]
+		if !ok {
+			rejects = append(rejects, RejectedRow{Element: el.Element, Reason: ReasonUnreachable})
+			continue
+		}
+		el.Tier = tier
+		kept = append(kept, el)
+	}
+	return kept, rejects
+}
+
+func isBaseRecipes(recipes [][]string) bool {
+	if len(recipes) == 0 {
+		return false
+	}
+	for _, r := range recipes {
+		if len(r) > 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,208 @@
+package server
+
+import (
+	"log/slog"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	recipe "github.com/Henshou/Tubes2_BE_CraftingTable.git/recipe"
+	scraper "github.com/Henshou/Tubes2_BE_CraftingTable.git/scraper"
+)
+
+const (
+	ScrapeIdle    = "idle"
+	ScrapeRunning = "running"
+	ScrapeDone    = "done"
+	ScrapeFailed  = "failed"
+)
+
+// ScrapeOptions are used by /api/admin/scrape. main sets them from its
+// flags; OutputDir is always config.DataDir.
+var ScrapeOptions = scraper.DefaultOptions()
+
+type ReloadResponse struct {
+	Datasets []DatasetDTO `json:"datasets"`
+}
+
+type FlushResponse struct {
+	Flushed int `json:"flushed"`
+}
+
+type ScrapeStatus struct {
+	State      string     `json:"state"`
+	Source     string     `json:"source,omitempty"`
+	StartedAt  *time.Time `json:"startedAt,omitempty"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+	Error      string     `json:"error,omitempty"`
+}
+
+type AdminJobsResponse struct {
+	Jobs []JobDTO `json:"jobs"`
+	// RunningSearches counts every search in progress, not only jobs.
+	RunningSearches int `json:"runningSearches"`
+}
+
+var scrape = struct {
+	sync.Mutex
+	status ScrapeStatus
+}{status: ScrapeStatus{State: ScrapeIdle}}
+
+// reloadData reads the datasets and aliases from disk again. Cached results
+// of the old datasets can't be served any more, so the cache is flushed.
+func reloadData() error {
+	if err := loadDatasets(); err != nil {
+		return err
+	}
+	if err := loadAliases(dataFile("aliases.json")); err != nil {
+		return err
+	}
+	results.flush()
+	return nil
+}
+
+func reloadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, &APIError{Status: http.StatusMethodNotAllowed, Code: CodeMethodNotAllowed, Message: "use POST"})
+		return
+	}
+	if err := reloadData(); err != nil {
+		writeError(w, &APIError{Status: http.StatusInternalServerError, Code: CodeInternal, Message: "reload failed: " + err.Error()})
+		return
+	}
+	resp := ReloadResponse{}
+	for _, name := range datasetNames() {
+		resp.Datasets = append(resp.Datasets, datasetDTO(name))
+	}
+	writeJSON(w, resp)
+}
+
+func cacheHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		writeError(w, &APIError{Status: http.StatusMethodNotAllowed, Code: CodeMethodNotAllowed, Message: "use DELETE"})
+		return
+	}
+	writeJSON(w, FlushResponse{Flushed: results.flush()})
+}
+
+// scrapeHandler reports the last scrape on GET and starts a new one on POST,
+// with ?source= naming a built-in source or one of config.ScrapeSources
+// (default la2). The datasets are reloaded once it succeeds.
+func scrapeHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		scrape.Lock()
+		defer scrape.Unlock()
+		writeJSON(w, scrape.status)
+	case http.MethodPost:
+		spec := r.URL.Query().Get("source")
+		if spec == "" {
+			spec = recipe.DefaultDataset
+		}
+		src, ok := scrapeSource(spec)
+		if !ok {
+			allowed := scrapeSourceNames()
+			writeError(w, invalidParameter(ParameterDetails{Parameter: "source", Value: spec, Allowed: allowed},
+				"source must be one of %s", strings.Join(allowed, ", ")))
+			return
+		}
+
+		scrape.Lock()
+		if scrape.status.State == ScrapeRunning {
+			scrape.Unlock()
+			writeError(w, &APIError{Status: http.StatusConflict, Code: CodeScrapeRunning, Message: "a scrape is already running"})
+			return
+		}
+		now := time.Now()
+		scrape.status = ScrapeStatus{State: ScrapeRunning, Source: src.Name(), StartedAt: &now}
+		status := scrape.status
+		scrape.Unlock()
+
+		go runScrape(src, requestLogger(r))
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Location", "/api/admin/scrape")
+		w.WriteHeader(http.StatusAccepted)
+		writeJSON(w, status)
+	default:
+		writeError(w, &APIError{Status: http.StatusMethodNotAllowed, Code: CodeMethodNotAllowed, Message: "use GET or POST"})
+	}
+}
+
+// scrapeSource resolves the source an admin asked for by name. Paths and
+// URLs can only come from the server's own configuration, so a request can't
+// make the server read local files or fetch arbitrary URLs.
+func scrapeSource(name string) (scraper.Source, bool) {
+	spec := name
+	switch name {
+	case "la2", "la1":
+	default:
+		i := slices.IndexFunc(config.ScrapeSources, func(s string) bool { return strings.HasPrefix(s, name+"=") })
+		if i < 0 {
+			return nil, false
+		}
+		spec = config.ScrapeSources[i]
+	}
+	src, err := scraper.SourceByName(spec)
+	return src, err == nil
+}
+
+func scrapeSourceNames() []string {
+	names := []string{"la2", "la1"}
+	for _, spec := range config.ScrapeSources {
+		name, _, _ := strings.Cut(spec, "=")
+		names = append(names, name)
+	}
+	return names
+}
+
+func runScrape(src scraper.Source, logger *slog.Logger) {
+	logger.Info("scrape started", "source", src.Name())
+	opts := ScrapeOptions
+	opts.OutputDir = config.DataDir
+	err := scraper.FindRecipes(src, opts)
+	if err == nil {
+		err = reloadData()
+	}
+
+	scrape.Lock()
+	defer scrape.Unlock()
+	now := time.Now()
+	scrape.status.FinishedAt = &now
+	if err != nil {
+		scrape.status.State, scrape.status.Error = ScrapeFailed, err.Error()
+		logger.Error("scrape failed", "source", src.Name(), "err", err)
+		return
+	}
+	scrape.status.State = ScrapeDone
+	logger.Info("scrape finished", "source", src.Name(), "duration_ms", now.Sub(*scrape.status.StartedAt).Milliseconds())
+}
+
+// adminJobsHandler lists every job, or only those in ?status=.
+func adminJobsHandler(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	jobs.Lock()
+	list := make([]*job, 0, len(jobs.byID))
+	for _, j := range jobs.byID {
+		list = append(list, j)
+	}
+	jobs.Unlock()
+
+	resp := AdminJobsResponse{Jobs: []JobDTO{}}
+	for _, j := range list {
+		dto := j.dto(false)
+		if status == "" || dto.Status == status {
+			resp.Jobs = append(resp.Jobs, dto)
+		}
+	}
+	sort.Slice(resp.Jobs, func(a, b int) bool {
+		return resp.Jobs[a].CreatedAt.Before(resp.Jobs[b].CreatedAt)
+	})
+	running.Lock()
+	resp.RunningSearches = len(running.searches)
+	running.Unlock()
+	writeJSON(w, resp)
+}
@@ -0,0 +1,136 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+const (
+	ScopePublic = "public"
+	ScopeAdmin  = "admin"
+)
+
+// APIKey is one entry of the key file, a JSON array of
+// {"name": ..., "key": ..., "scope": "public" | "admin"}. Admin keys can
+// also do everything public keys can.
+type APIKey struct {
+	Name  string `json:"name"`
+	Key   string `json:"key"`
+	Scope string `json:"scope"`
+}
+
+// apiKeys maps the hash of every key to its entry. It is empty when no key
+// file is configured, which turns authentication off.
+var apiKeys = make(map[string]APIKey)
+
+func hashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func loadAPIKeys(file string) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	var list []APIKey
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("%s: %w", file, err)
+	}
+
+	loaded := make(map[string]APIKey, len(list))
+	names := make(map[string]bool, len(list))
+	for i, k := range list {
+		switch {
+		case k.Name == "" || k.Key == "":
+			return fmt.Errorf("%s: key %d needs a name and a key", file, i+1)
+		case k.Scope != ScopePublic && k.Scope != ScopeAdmin:
+			return fmt.Errorf("%s: key %q has scope %q, want %s or %s", file, k.Name, k.Scope, ScopePublic, ScopeAdmin)
+		case names[k.Name]:
+			return fmt.Errorf("%s: two keys are named %q", file, k.Name)
+		}
+		names[k.Name] = true
+		loaded[hashKey(k.Key)] = k
+	}
+	apiKeys = loaded
+	return nil
+}
+
+// requestKey is the key a request was sent with: X-API-Key, a bearer token,
+// or ?api_key= for EventSource and WebSocket clients, which can't set
+// headers.
+func requestKey(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return strings.TrimSpace(token)
+	}
+	return r.URL.Query().Get("api_key")
+}
+
+// withAuth checks the API key of every /api request when a key file is
+// configured. A request without a key is let through as anonymous unless
+// config.RequireKey is set; icons are always public, since <img> tags can't
+// send a key. It runs after withRateLimit, so guessing keys is throttled
+// like any other request.
+func withAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(apiKeys) == 0 || !strings.HasPrefix(r.URL.Path, "/api/") || strings.HasPrefix(r.URL.Path, "/api/images/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if requestKey(r) == "" {
+			if config.RequireKey {
+				writeError(w, &APIError{Status: http.StatusUnauthorized, Code: CodeUnauthorized, Message: "an API key is required"})
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+		if _, ok := keyFor(r); !ok {
+			writeError(w, &APIError{Status: http.StatusUnauthorized, Code: CodeUnauthorized, Message: "unknown API key"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// keyFor is the known API key r was sent with. It is looked up again rather
+// than passed down in the context, since a request copied with a new context
+// hides the route ServeMux matched from withMetrics.
+func keyFor(r *http.Request) (APIKey, bool) {
+	key := requestKey(r)
+	if key == "" {
+		return APIKey{}, false
+	}
+	k, ok := apiKeys[hashKey(key)]
+	return k, ok
+}
+
+// requireAdmin only lets requests with an admin key through to h.
+func requireAdmin(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if len(apiKeys) == 0 {
+			writeError(w, &APIError{Status: http.StatusForbidden, Code: CodeForbidden, Message: "admin endpoints are off; start the server with an api-key-file"})
+			return
+		}
+		k, ok := keyFor(r)
+		if !ok {
+			writeError(w, &APIError{Status: http.StatusUnauthorized, Code: CodeUnauthorized, Message: "an admin API key is required"})
+			return
+		}
+		if k.Scope != ScopeAdmin {
+			writeError(w, &APIError{Status: http.StatusForbidden, Code: CodeForbidden, Message: fmt.Sprintf("key %q can't use admin endpoints", k.Name)})
+			return
+		}
+		requestLogger(r).Info("admin request", "key", k.Name, "method", r.Method, "path", r.URL.Path)
+		h(w, r)
+	}
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const MaxBatchItems = 100
+
+type BatchRequest struct {
+	Items []SearchRequest `json:"items"`
+}
+
+// BatchItemResult holds either the TreeResponse of one item or its error.
+type BatchItemResult struct {
+	Index  int             `json:"index"`
+	Target string          `json:"target"`
+	Method string          `json:"method"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  *APIError       `json:"error,omitempty"`
+}
+
+type BatchResponse struct {
+	Results []BatchItemResult `json:"results"`
+}
+
+// batchHandler runs every item of a batch, config.BatchConcurrency at a
+// time but no more than the client may run at once. With ?format=ndjson (or Accept: application/x-ndjson) each result is
+// written as one line as soon as it's ready; otherwise all results are
+// returned in request order.
+func batchHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, &APIError{Status: http.StatusMethodNotAllowed, Code: CodeMethodNotAllowed, Message: "use POST"})
+		return
+	}
+
+	var req BatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, &APIError{Status: http.StatusBadRequest, Code: CodeInvalidBody, Message: "invalid JSON body: " + err.Error()})
+		return
+	}
+	if len(req.Items) == 0 {
+		writeError(w, missingParameter("items"))
+		return
+	}
+	if len(req.Items) > MaxBatchItems {
+		writeError(w, invalidParameter(ParameterDetails{Parameter: "items", Value: fmt.Sprint(len(req.Items)), Max: MaxBatchItems},
+			"a batch can hold at most %d items", MaxBatchItems))
+		return
+	}
+
+	ndjson := r.URL.Query().Get("format") == "ndjson" || strings.Contains(r.Header.Get("Accept"), "application/x-ndjson")
+	logger := requestLogger(r)
+	logger.Debug("batch requested", "items", len(req.Items), "ndjson", ndjson)
+	concurrency := clientSearchSlots(config.BatchConcurrency)
+	rounds := (len(req.Items) + concurrency - 1) / concurrency
+	extendWriteDeadline(w, r, time.Duration(rounds)*MaxTimeout)
+
+	done := make(chan BatchItemResult)
+	go runBatch(req.Items, concurrency, clientID(r), logger, done)
+
+	if !ndjson {
+		resp := BatchResponse{Results: make([]BatchItemResult, len(req.Items))}
+		for res := range done {
+			resp.Results[res.Index] = res
+		}
+		writeJSON(w, resp)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+	for res := range done {
+		if err := enc.Encode(res); err != nil {
+			logger.Debug("cannot write batch result", "err", err)
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+}
+
+func runBatch(items []SearchRequest, concurrency int, client string, logger *slog.Logger, done chan<- BatchItemResult) {
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, item := range items {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			done <- runBatchItem(i, item, client, logger.With("item", i))
+		}()
+	}
+	wg.Wait()
+	close(done)
+}
+
+func runBatchItem(index int, item SearchRequest, client string, logger *slog.Logger) BatchItemResult {
+	res := BatchItemResult{Index: index, Target: item.Target, Method: item.Method}
+	p, apiErr := parseSearchParams(item.query(), "")
+	if apiErr != nil {
+		res.Error = apiErr
+		return res
+	}
+	res.Target, res.Method = p.Target, p.Method
+	p.Logger, p.Client = logger, client
+
+	cached, _, apiErr := cachedSearch(p)
+	if apiErr != nil {
+		res.Error = apiErr
+		return res
+	}
+	res.Result = cached.body
+	return res
+}
@@ -0,0 +1,172 @@
+package server
+
+import (
+	"bytes"
+	"container/list"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	recipe "github.com/Henshou/Tubes2_BE_CraftingTable.git/recipe"
+)
+
+// SearchCacheMaxAge is how long clients may reuse a search result without
+// asking again. Results only change when the dataset does.
+const SearchCacheMaxAge = 300
+
+type cachedResult struct {
+	key         string
+	body        []byte
+	contentType string
+	// etag is empty for results that must not be cached.
+	etag string
+}
+
+// resultCache is an LRU of encoded search responses, bounded by
+// config.ResultCacheEntries and config.ResultCacheBytes.
+type resultCache struct {
+	mu      sync.Mutex
+	order   *list.List
+	entries map[string]*list.Element
+	size    int
+
+	hits, misses atomic.Int64
+}
+
+var results = &resultCache{order: list.New(), entries: make(map[string]*list.Element)}
+
+// cacheKey identifies the result of a search: the dataset and the version
+// it was loaded at, the method, the target and every constraint that shapes
+// the tree.
+func (p searchParams) cacheKey() string {
+	return fmt.Sprintf("%s@%s|%s|%s|%d|%d|%s", p.Dataset, datasetVersion(p.Dataset), p.Method, p.Target, p.Count, p.Depth, p.Format)
+}
+
+func (c *resultCache) get(key string) (*cachedResult, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[key]
+	if !ok {
+		c.misses.Add(1)
+		return nil, false
+	}
+	c.hits.Add(1)
+	c.order.MoveToFront(el)
+	return el.Value.(*cachedResult), true
+}
+
+func (c *resultCache) add(res *cachedResult) *cachedResult {
+	key, body := res.key, res.body
+	res.etag = `"` + contentHash(body) + `"`
+	if len(body) > config.ResultCacheBytes {
+		return res
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[key]; ok {
+		c.size -= len(el.Value.(*cachedResult).body)
+		c.order.Remove(el)
+	}
+	c.entries[key] = c.order.PushFront(res)
+	c.size += len(body)
+
+	for c.order.Len() > config.ResultCacheEntries || c.size > config.ResultCacheBytes {
+		oldest := c.order.Back()
+		old := oldest.Value.(*cachedResult)
+		c.order.Remove(oldest)
+		delete(c.entries, old.key)
+		c.size -= len(old.body)
+	}
+	return res
+}
+
+// cachedSearch returns the encoded result of p, running the search unless
+// the cache already holds it. Searches that timed out aren't cached.
+func cachedSearch(p searchParams) (res *cachedResult, hit bool, apiErr *APIError) {
+	key := p.cacheKey()
+	if res, ok := results.get(key); ok {
+		p.logger().Debug("result cache hit", "key", key)
+		return res, true, nil
+	}
+
+	res = &cachedResult{key: key, contentType: "application/json"}
+	var timedOut bool
+	s, apiErr := startSearch(p, nil)
+	if apiErr != nil {
+		return nil, false, apiErr
+	}
+	if p.Format == FormatJSON {
+		result := s.result()
+		var err error
+		if res.body, err = json.Marshal(result); err != nil {
+			return nil, false, &APIError{Status: http.StatusInternalServerError, Code: CodeInternal, Message: "json encode error"}
+		}
+		timedOut = result.TimedOut
+	} else {
+		timedOut = s.finish().TimedOut
+		var buf bytes.Buffer
+		if err := recipe.WriteGraph(&buf, recipe.TreeGraph(s.root, p.Recipes), p.Format); err != nil {
+			return nil, false, &APIError{Status: http.StatusInternalServerError, Code: CodeInternal, Message: "cannot export the tree: " + err.Error()}
+		}
+		res.body, res.contentType = buf.Bytes(), recipe.ContentType(p.Format)
+	}
+	if timedOut {
+		return res, false, nil
+	}
+	return results.add(res), false, nil
+}
+
+func (c *resultCache) hitRatio() float64 {
+	hits, misses := c.hits.Load(), c.misses.Load()
+	if hits+misses == 0 {
+		return 0
+	}
+	return float64(hits) / float64(hits+misses)
+}
+
+// flush empties the cache and returns how many results it held.
+func (c *resultCache) flush() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := c.order.Len()
+	c.order.Init()
+	c.entries = make(map[string]*list.Element)
+	c.size = 0
+	return n
+}
+
+// notModified reports whether the client already holds the version of the
+// response identified by etag, answering with 304 if so.
+func notModified(w http.ResponseWriter, r *http.Request, etag string) bool {
+	w.Header().Set("ETag", etag)
+	for _, match := range strings.Split(r.Header.Get("If-None-Match"), ",") {
+		match = strings.TrimSpace(match)
+		if match == etag || match == "*" || strings.TrimPrefix(match, "W/") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
+
+func writeCached(w http.ResponseWriter, r *http.Request, res *cachedResult, hit bool) {
+	if hit {
+		w.Header().Set("X-Cache", "HIT")
+	} else {
+		w.Header().Set("X-Cache", "MISS")
+	}
+	if res.etag == "" {
+		w.Header().Set("Cache-Control", "no-store")
+	} else {
+		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", SearchCacheMaxAge))
+		if notModified(w, r, res.etag) {
+			return
+		}
+	}
+	w.Header().Set("Content-Type", res.contentType)
+	w.Write(res.body)
+}
@@ -0,0 +1,266 @@
+package server
+
+import (
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+
+	recipe "github.com/Henshou/Tubes2_BE_CraftingTable.git/recipe"
+)
+
+type ElementSummaryDTO struct {
+	Name     string `json:"name"`
+	Tier     int    `json:"tier"`
+	ImageURL string `json:"imageUrl"`
+}
+
+type ElementListResponse struct {
+	Elements []ElementSummaryDTO `json:"elements"`
+	Total    int                 `json:"total"`
+	Offset   int                 `json:"offset"`
+	Limit    int                 `json:"limit"`
+}
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 500
+)
+
+type catalogEntry struct {
+	name  string
+	lower string
+	tier  int
+}
+
+// catalog is a search index over one dataset: the element names sorted
+// case-insensitively for prefix lookups, and the reverse of the recipe
+// map so an element's products don't need a full scan.
+type catalog struct {
+	entries    []catalogEntry
+	byName     map[string]catalogEntry
+	normalized map[string]string
+	products   map[string][]string
+}
+
+var catalogs = make(map[string]*catalog)
+
+func newCatalog(recipes map[string]recipe.Recipe) *catalog {
+	c := &catalog{
+		byName:     make(map[string]catalogEntry),
+		normalized: make(map[string]string),
+		products:   make(map[string][]string),
+	}
+	for name, el := range recipes {
+		entry := catalogEntry{name: name, lower: strings.ToLower(name), tier: el.Tier}
+		c.entries = append(c.entries, entry)
+		c.byName[name] = entry
+		c.normalized[normalizeName(name)] = name
+		seen := make(map[string]bool)
+		for _, ingredients := range el.Recipes {
+			for _, ingredient := range ingredients {
+				if !seen[ingredient] {
+					seen[ingredient] = true
+					c.products[ingredient] = append(c.products[ingredient], name)
+				}
+			}
+		}
+	}
+	sort.Slice(c.entries, func(i, j int) bool { return c.entries[i].lower < c.entries[j].lower })
+	for _, products := range c.products {
+		sort.Strings(products)
+	}
+	return c
+}
+
+// prefix returns the entries whose name starts with q, in name order.
+func (c *catalog) prefix(q string) []catalogEntry {
+	start := sort.Search(len(c.entries), func(i int) bool { return c.entries[i].lower >= q })
+	end := start
+	for end < len(c.entries) && strings.HasPrefix(c.entries[end].lower, q) {
+		end++
+	}
+	return c.entries[start:end]
+}
+
+// fuzzy returns the entries containing the letters of q in order, best
+// matches first: prefixes, then substrings, then the tightest spread.
+func (c *catalog) fuzzy(q string) []catalogEntry {
+	type scored struct {
+		entry catalogEntry
+		score int
+	}
+	var matches []scored
+	for _, e := range c.entries {
+		if score, ok := fuzzyScore(e.lower, q); ok {
+			matches = append(matches, scored{e, score})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].score < matches[j].score })
+
+	result := make([]catalogEntry, len(matches))
+	for i, m := range matches {
+		result[i] = m.entry
+	}
+	return result
+}
+
+func fuzzyScore(name, q string) (int, bool) {
+	if strings.HasPrefix(name, q) {
+		return 0, true
+	}
+	if i := strings.Index(name, q); i >= 0 {
+		return 1000 + i, true
+	}
+	first, last, j := -1, -1, 0
+	for i := 0; i < len(name) && j < len(q); i++ {
+		if name[i] == q[j] {
+			if first < 0 {
+				first = i
+			}
+			last = i
+			j++
+		}
+	}
+	if j < len(q) {
+		return 0, false
+	}
+	return 2000 + (last - first), true
+}
+
+func elementsHandler(w http.ResponseWriter, r *http.Request) {
+	name, recipes, apiErr := datasetFor(r)
+	if apiErr != nil {
+		writeError(w, apiErr)
+		return
+	}
+	c := catalogFor(name)
+	query := r.URL.Query()
+
+	q := strings.ToLower(strings.TrimSpace(query.Get("q")))
+	var entries []catalogEntry
+	switch match := query.Get("match"); {
+	case q == "":
+		entries = c.entries
+	case match == "" || match == "prefix":
+		entries = c.prefix(q)
+	case match == "fuzzy":
+		entries = c.fuzzy(q)
+	default:
+		writeError(w, invalidParameter(ParameterDetails{Parameter: "match", Value: match, Allowed: []string{"prefix", "fuzzy"}}, "match must be prefix or fuzzy"))
+		return
+	}
+
+	highest := 0
+	for _, el := range recipes {
+		highest = max(highest, el.Tier)
+	}
+	tier, apiErr := intParam(query, "tier", -1, 0, highest)
+	if apiErr != nil {
+		writeError(w, apiErr)
+		return
+	}
+	minDefault, maxDefault := 0, highest
+	if tier >= 0 {
+		minDefault, maxDefault = tier, tier
+	}
+	minTier, apiErr := intParam(query, "minTier", minDefault, 0, highest)
+	if apiErr != nil {
+		writeError(w, apiErr)
+		return
+	}
+	maxTier, apiErr := intParam(query, "maxTier", maxDefault, 0, highest)
+	if apiErr != nil {
+		writeError(w, apiErr)
+		return
+	}
+
+	filtered := make([]catalogEntry, 0, len(entries))
+	for _, e := range entries {
+		if e.tier >= minTier && e.tier <= maxTier {
+			filtered = append(filtered, e)
+		}
+	}
+
+	switch sortBy := query.Get("sort"); sortBy {
+	case "":
+	case "name":
+		sort.SliceStable(filtered, func(i, j int) bool { return filtered[i].lower < filtered[j].lower })
+	case "tier":
+		sort.SliceStable(filtered, func(i, j int) bool { return filtered[i].tier < filtered[j].tier })
+	default:
+		writeError(w, invalidParameter(ParameterDetails{Parameter: "sort", Value: sortBy, Allowed: []string{"name", "tier"}}, "sort must be name or tier"))
+		return
+	}
+	switch order := query.Get("order"); order {
+	case "", "asc":
+	case "desc":
+		for i, j := 0, len(filtered)-1; i < j; i, j = i+1, j-1 {
+			filtered[i], filtered[j] = filtered[j], filtered[i]
+		}
+	default:
+		writeError(w, invalidParameter(ParameterDetails{Parameter: "order", Value: order, Allowed: []string{"asc", "desc"}}, "order must be asc or desc"))
+		return
+	}
+
+	limit, apiErr := intParam(query, "limit", defaultPageSize, 1, maxPageSize)
+	if apiErr != nil {
+		writeError(w, apiErr)
+		return
+	}
+	offset, apiErr := intParam(query, "offset", 0, 0, math.MaxInt32)
+	if apiErr != nil {
+		writeError(w, apiErr)
+		return
+	}
+
+	resp := ElementListResponse{
+		Elements: make([]ElementSummaryDTO, 0, limit),
+		Total:    len(filtered),
+		Offset:   offset,
+		Limit:    limit,
+	}
+	for i := offset; i < len(filtered) && i < offset+limit; i++ {
+		el := recipes[filtered[i].name]
+		resp.Elements = append(resp.Elements, ElementSummaryDTO{Name: el.Name, Tier: el.Tier, ImageURL: el.ImageURL})
+	}
+	writeJSON(w, resp)
+}
+
+func elementHandler(w http.ResponseWriter, r *http.Request) {
+	name, recipes, apiErr := datasetFor(r)
+	if apiErr != nil {
+		writeError(w, apiErr)
+		return
+	}
+	target, apiErr := lookupElement(name, r.PathValue("name"))
+	if apiErr != nil {
+		writeError(w, apiErr)
+		return
+	}
+	el := recipes[target]
+
+	direct := make([][]string, 0, len(el.Recipes))
+	for _, ingredients := range el.Recipes {
+		if len(ingredients) > 0 {
+			direct = append(direct, ingredients)
+		}
+	}
+	products := catalogFor(name).products[el.Name]
+	if products == nil {
+		products = []string{}
+	}
+
+	writeJSON(w, ElementDTO{
+		Name:        el.Name,
+		Tier:        el.Tier,
+		ImageURL:    el.ImageURL,
+		Recipes:     direct,
+		Products:    products,
+		PageURL:     el.PageURL,
+		Description: el.Description,
+		UsedIn:      el.UsedIn,
+		Categories:  el.Categories,
+		Pack:        el.Pack,
+	})
+}
@@ -0,0 +1,142 @@
+package server
+
+import (
+	"net/http"
+	"runtime"
+	"runtime/metrics"
+	"slices"
+	"time"
+
+	recipe "github.com/Henshou/Tubes2_BE_CraftingTable.git/recipe"
+)
+
+const heapMetric = "/memory/classes/heap/objects:bytes"
+
+// MethodComparison is how one method did on a comparison run. PeakMemory is
+// the most heap in use above what was live when the run started, sampled
+// every millisecond and once more when the search returns.
+type MethodComparison struct {
+	SearchTotals
+	PeakMemory          uint64 `json:"peakMemory"` // bytes
+	DuplicateExpansions int    `json:"duplicateExpansions"`
+}
+
+type CompareResponse struct {
+	Dataset string             `json:"dataset"`
+	Target  string             `json:"target"`
+	Count   int                `json:"count"`
+	Depth   int                `json:"depth,omitempty"`
+	Results []MethodComparison `json:"results"`
+}
+
+// compareHandler runs every registered method, one after the other, on the
+// same copy of the dataset and reports their metrics side by side.
+func compareHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	query.Set("method", methodNames()[0])
+	p, apiErr := parseSearchParams(query, "")
+	if apiErr != nil {
+		writeError(w, apiErr)
+		return
+	}
+	p.Logger, p.Client = requestLogger(r), clientID(r)
+	p.Logger.Debug("comparison requested", "target", p.Target, "count", p.Count, "depth", p.Depth)
+
+	extendWriteDeadline(w, r, time.Duration(len(methods))*p.Timeout)
+	p.Recipes = snapshotRecipes(p.Recipes)
+	resp := CompareResponse{Dataset: p.Dataset, Target: p.Target, Count: p.Count, Depth: p.Depth}
+	for _, name := range methodNames() {
+		p.Method = name
+		result, apiErr := compareMethod(p)
+		if apiErr != nil {
+			writeError(w, apiErr)
+			return
+		}
+		resp.Results = append(resp.Results, result)
+	}
+	writeJSON(w, resp)
+}
+
+func snapshotRecipes(recipes map[string]recipe.Recipe) map[string]recipe.Recipe {
+	snapshot := make(map[string]recipe.Recipe, len(recipes))
+	for name, r := range recipes {
+		r.Recipes = slices.Clone(r.Recipes)
+		for i, pair := range r.Recipes {
+			r.Recipes[i] = slices.Clone(pair)
+		}
+		snapshot[name] = r
+	}
+	return snapshot
+}
+
+func compareMethod(p searchParams) (MethodComparison, *APIError) {
+	runtime.GC()
+	sample := []metrics.Sample{{Name: heapMetric}}
+	metrics.Read(sample)
+	baseline := sample[0].Value.Uint64()
+
+	var peak uint64
+	stop := make(chan struct{})
+	sampled := make(chan struct{})
+	go func() {
+		defer close(sampled)
+		ticker := time.NewTicker(time.Millisecond)
+		defer ticker.Stop()
+		measure := func() {
+			metrics.Read(sample)
+			if heap := sample[0].Value.Uint64(); heap > baseline && heap-baseline > peak {
+				peak = heap - baseline
+			}
+		}
+		for {
+			measure()
+			select {
+			case <-stop:
+				measure()
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	s, apiErr := startSearch(p, nil)
+	if apiErr != nil {
+		close(stop)
+		<-sampled
+		return MethodComparison{}, apiErr
+	}
+	s.wg.Wait()
+	close(stop)
+	<-sampled
+
+	duplicates := duplicateExpansions(s.root, p.Recipes)
+	return MethodComparison{
+		SearchTotals:        s.finish(),
+		PeakMemory:          peak,
+		DuplicateExpansions: duplicates,
+	}, nil
+}
+
+// duplicateExpansions counts the nodes that expand an element already
+// expanded elsewhere in the tree.
+func duplicateExpansions(root *recipe.RecipeTreeNode, recipes map[string]recipe.Recipe) int {
+	expanded := make(map[string]bool)
+	duplicates := 0
+	var walk func(node *recipe.RecipeTreeNode)
+	walk = func(node *recipe.RecipeTreeNode) {
+		if node == nil || len(node.Children) == 0 || recipe.IsBaseElement(node.Name, recipes) {
+			return
+		}
+		if expanded[node.Name] {
+			duplicates++
+		}
+		expanded[node.Name] = true
+		for _, group := range node.Children {
+			for _, child := range group {
+				walk(child)
+			}
+		}
+	}
+	walk(root)
+	return duplicates
+}
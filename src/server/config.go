@@ -0,0 +1,350 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	scraper "github.com/Henshou/Tubes2_BE_CraftingTable.git/scraper"
+)
+
+// Config holds the server settings. Each one is read from, in increasing
+// order of precedence: DefaultConfig, the JSON file given by -config (or
+// CT_CONFIG), a CT_* environment variable and its command-line flag.
+type Config struct {
+	Addr string
+	// DataDir is where recipes.json, the other datasets and aliases.json
+	// are loaded from.
+	DataDir        string
+	AllowedOrigins []string
+	// APIKeyFile lists the API keys; without it the admin endpoints are
+	// off. RequireKey makes the public routes need a key too.
+	APIKeyFile string
+	RequireKey bool
+	// ScrapeSources are the name=path sources /api/admin/scrape may use
+	// besides la2 and la1, by name.
+	ScrapeSources []string
+
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	IdleTimeout  time.Duration
+	// ShutdownTimeout is how long open requests get to finish on SIGTERM.
+	ShutdownTimeout time.Duration
+
+	MaxCount int
+	// MaxConcurrentSearches caps the searches running at once; the others
+	// wait for a free slot. 0 means no cap.
+	MaxConcurrentSearches int
+	// ClientConcurrentSearches caps the searches one client runs at once,
+	// counting its queued jobs.
+	ClientConcurrentSearches int
+	// RateLimit is how many /api requests a second each client may make,
+	// with bursts of up to RateBurst. 0 means no limit.
+	RateLimit int
+	RateBurst int
+
+	JobWorkers   int
+	JobRetention time.Duration
+	// JobMaxTimeout caps the timeout of a job, which may run well past
+	// MaxTimeout since nobody waits on it.
+	JobMaxTimeout time.Duration
+
+	ResultCacheEntries int
+	ResultCacheBytes   int
+	BatchConcurrency   int
+
+	// LogLevel is debug, info, warn or error; debug also traces every node a
+	// search visits. LogFormat is text or json.
+	LogLevel  string
+	LogFormat string
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Addr:           ":8080",
+		DataDir:        ".",
+		AllowedOrigins: []string{"*"},
+		ReadTimeout:    15 * time.Second,
+		// Longer than MaxTimeout, so streamed searches aren't cut off. Batches
+		// and comparisons, which run several searches, extend it.
+		WriteTimeout:             2 * time.Minute,
+		IdleTimeout:              2 * time.Minute,
+		ShutdownTimeout:          30 * time.Second,
+		MaxCount:                 10000,
+		MaxConcurrentSearches:    32,
+		ClientConcurrentSearches: 4,
+		RateLimit:                10,
+		RateBurst:                20,
+		JobWorkers:               4,
+		JobRetention:             10 * time.Minute,
+		JobMaxTimeout:            30 * time.Minute,
+		ResultCacheEntries:       256,
+		ResultCacheBytes:         64 << 20,
+		BatchConcurrency:         4,
+		LogLevel:                 "info",
+		LogFormat:                "text",
+	}
+}
+
+// config is the configuration the server was started with.
+var config = DefaultConfig()
+
+// setting is one Config field as it is named in flags, the config file and
+// the environment.
+type setting struct {
+	name  string
+	usage string
+	set   func(c *Config, value string) error
+	get   func(c *Config) string
+	// isBool settings can be given as a bare flag, like -require-key.
+	isBool bool
+}
+
+var settings = []setting{
+	stringSetting("addr", "address to listen on", func(c *Config) *string { return &c.Addr }),
+	stringSetting("data-dir", "directory holding the dataset files and aliases.json", func(c *Config) *string { return &c.DataDir }),
+	stringSetting("api-key-file", "JSON file listing the API keys and their scopes; admin endpoints are off without it", func(c *Config) *string { return &c.APIKeyFile }),
+	boolSetting("require-key", "make the public routes need an API key too", func(c *Config) *bool { return &c.RequireKey }),
+	listSetting("scrape-sources", "comma-separated name=path sources the admin scrape endpoint may use besides la2 and la1", func(c *Config) *[]string { return &c.ScrapeSources }),
+	listSetting("allowed-origins", "comma-separated origins allowed to call the API, * for any", func(c *Config) *[]string { return &c.AllowedOrigins }),
+	durationSetting("read-timeout", "timeout for reading a request", func(c *Config) *time.Duration { return &c.ReadTimeout }),
+	durationSetting("write-timeout", "timeout for writing a response, 0 for none", func(c *Config) *time.Duration { return &c.WriteTimeout }),
+	durationSetting("idle-timeout", "how long idle keep-alive connections are kept", func(c *Config) *time.Duration { return &c.IdleTimeout }),
+	durationSetting("shutdown-timeout", "how long open requests get to finish when the server stops", func(c *Config) *time.Duration { return &c.ShutdownTimeout }),
+	intSetting("max-count", "largest count a search may ask for", func(c *Config) *int { return &c.MaxCount }),
+	intSetting("max-concurrent-searches", "searches running at the same time, 0 for no limit", func(c *Config) *int { return &c.MaxConcurrentSearches }),
+	intSetting("client-concurrent-searches", "searches one client may run at the same time, 0 for no limit", func(c *Config) *int { return &c.ClientConcurrentSearches }),
+	intSetting("rate-limit", "/api requests a second each client may make, 0 for no limit", func(c *Config) *int { return &c.RateLimit }),
+	intSetting("rate-burst", "requests a client may make at once on top of rate-limit", func(c *Config) *int { return &c.RateBurst }),
+	intSetting("job-workers", "searches the job API runs at the same time", func(c *Config) *int { return &c.JobWorkers }),
+	durationSetting("job-retention", "how long finished jobs are kept", func(c *Config) *time.Duration { return &c.JobRetention }),
+	durationSetting("job-max-timeout", "longest timeout a job may ask for, and the timeout of jobs that don't", func(c *Config) *time.Duration { return &c.JobMaxTimeout }),
+	intSetting("result-cache-entries", "search results kept in the cache", func(c *Config) *int { return &c.ResultCacheEntries }),
+	intSetting("result-cache-bytes", "total size of the search results kept in the cache", func(c *Config) *int { return &c.ResultCacheBytes }),
+	intSetting("batch-concurrency", "searches of one batch request that run at the same time", func(c *Config) *int { return &c.BatchConcurrency }),
+	stringSetting("log-level", "debug, info, warn or error; debug traces every visited node", func(c *Config) *string { return &c.LogLevel }),
+	stringSetting("log-format", "text or json", func(c *Config) *string { return &c.LogFormat }),
+}
+
+func stringSetting(name, usage string, field func(c *Config) *string) setting {
+	return setting{
+		name:  name,
+		usage: usage,
+		set:   func(c *Config, value string) error { *field(c) = value; return nil },
+		get:   func(c *Config) string { return *field(c) },
+	}
+}
+
+func listSetting(name, usage string, field func(c *Config) *[]string) setting {
+	return setting{
+		name:  name,
+		usage: usage,
+		set: func(c *Config, value string) error {
+			var list []string
+			for _, item := range strings.Split(value, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					list = append(list, item)
+				}
+			}
+			*field(c) = list
+			return nil
+		},
+		get: func(c *Config) string { return strings.Join(*field(c), ",") },
+	}
+}
+
+func boolSetting(name, usage string, field func(c *Config) *bool) setting {
+	return setting{
+		name:  name,
+		usage: usage,
+		set: func(c *Config, value string) error {
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("%s must be true or false, got %q", name, value)
+			}
+			*field(c) = b
+			return nil
+		},
+		get:    func(c *Config) string { return strconv.FormatBool(*field(c)) },
+		isBool: true,
+	}
+}
+
+func intSetting(name, usage string, field func(c *Config) *int) setting {
+	return setting{
+		name:  name,
+		usage: usage,
+		set: func(c *Config, value string) error {
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("%s must be a whole number, got %q", name, value)
+			}
+			*field(c) = n
+			return nil
+		},
+		get: func(c *Config) string { return strconv.Itoa(*field(c)) },
+	}
+}
+
+func durationSetting(name, usage string, field func(c *Config) *time.Duration) setting {
+	return setting{
+		name:  name,
+		usage: usage,
+		set: func(c *Config, value string) error {
+			d, err := time.ParseDuration(value)
+			if err != nil {
+				return fmt.Errorf("%s must be a duration like 30s, got %q", name, value)
+			}
+			*field(c) = d
+			return nil
+		},
+		get: func(c *Config) string { return field(c).String() },
+	}
+}
+
+func envName(name string) string {
+	return "CT_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// ConfigFlags adds -config and a flag for every setting to fs. The returned
+// function builds the Config once fs has been parsed.
+func ConfigFlags(fs *flag.FlagSet) func() (Config, error) {
+	defaults := DefaultConfig()
+	file := fs.String("config", "", "JSON file with server settings (env CT_CONFIG)")
+	flagValues := make(map[string]string)
+	for _, s := range settings {
+		usage := fmt.Sprintf("%s (env %s, default %q)", s.usage, envName(s.name), s.get(&defaults))
+		parse := func(value string) error {
+			scratch := DefaultConfig()
+			if err := s.set(&scratch, value); err != nil {
+				return err
+			}
+			flagValues[s.name] = value
+			return nil
+		}
+		if s.isBool {
+			fs.BoolFunc(s.name, usage, parse)
+		} else {
+			fs.Func(s.name, usage, parse)
+		}
+	}
+
+	return func() (Config, error) {
+		cfg := DefaultConfig()
+		path := *file
+		if path == "" {
+			path = os.Getenv("CT_CONFIG")
+		}
+		if path != "" {
+			if err := cfg.loadFile(path); err != nil {
+				return cfg, err
+			}
+		}
+		for _, s := range settings {
+			if value, ok := os.LookupEnv(envName(s.name)); ok {
+				if err := s.set(&cfg, value); err != nil {
+					return cfg, fmt.Errorf("%s: %w", envName(s.name), err)
+				}
+			}
+		}
+		for _, s := range settings {
+			if value, ok := flagValues[s.name]; ok {
+				s.set(&cfg, value)
+			}
+		}
+		return cfg, cfg.validate()
+	}
+}
+
+// loadFile reads a JSON object keyed by setting name. Values may be strings,
+// numbers or, for lists, arrays of strings.
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var values map[string]json.RawMessage
+	if err := json.Unmarshal(data, &values); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	for key, raw := range values {
+		i := -1
+		for j, s := range settings {
+			if s.name == key {
+				i = j
+			}
+		}
+		if i < 0 {
+			return fmt.Errorf("%s: unknown setting %q", path, key)
+		}
+
+		value := string(raw)
+		var str string
+		var list []string
+		if json.Unmarshal(raw, &str) == nil {
+			value = str
+		} else if json.Unmarshal(raw, &list) == nil {
+			value = strings.Join(list, ",")
+		}
+		if err := settings[i].set(c, value); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+func (c Config) validate() error {
+	var errs []error
+	if c.RequireKey && c.APIKeyFile == "" {
+		errs = append(errs, errors.New("require-key needs api-key-file"))
+	}
+	if len(c.AllowedOrigins) == 0 {
+		errs = append(errs, errors.New("allowed-origins must not be empty"))
+	}
+	for _, spec := range c.ScrapeSources {
+		if !strings.Contains(spec, "=") {
+			errs = append(errs, fmt.Errorf("scrape-sources: %q is not name=path", spec))
+		} else if _, err := scraper.SourceByName(spec); err != nil {
+			errs = append(errs, fmt.Errorf("scrape-sources: %w", err))
+		}
+	}
+	if c.ReadTimeout < 0 || c.WriteTimeout < 0 || c.IdleTimeout < 0 || c.ShutdownTimeout < 0 {
+		errs = append(errs, errors.New("timeouts must not be negative"))
+	}
+	if c.MaxCount < 1 {
+		errs = append(errs, errors.New("max-count must be at least 1"))
+	}
+	if c.MaxConcurrentSearches < 0 || c.ClientConcurrentSearches < 0 {
+		errs = append(errs, errors.New("max-concurrent-searches and client-concurrent-searches must not be negative"))
+	}
+	if c.RateLimit < 0 {
+		errs = append(errs, errors.New("rate-limit must not be negative"))
+	}
+	if c.RateLimit > 0 && c.RateBurst < 1 {
+		errs = append(errs, errors.New("rate-burst must be at least 1"))
+	}
+	if c.JobWorkers < 1 || c.BatchConcurrency < 1 {
+		errs = append(errs, errors.New("job-workers and batch-concurrency must be at least 1"))
+	}
+	if c.JobMaxTimeout < MinTimeout {
+		errs = append(errs, fmt.Errorf("job-max-timeout must be at least %s", MinTimeout))
+	}
+	if c.ResultCacheEntries < 0 || c.ResultCacheBytes < 0 {
+		errs = append(errs, errors.New("result cache limits must not be negative"))
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.LogLevel)); err != nil {
+		errs = append(errs, fmt.Errorf("log-level must be debug, info, warn or error, got %q", c.LogLevel))
+	}
+	if c.LogFormat != "text" && c.LogFormat != "json" {
+		errs = append(errs, fmt.Errorf("log-format must be text or json, got %q", c.LogFormat))
+	}
+	return errors.Join(errs...)
+}
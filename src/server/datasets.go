@@ -0,0 +1,161 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	recipe "github.com/Henshou/Tubes2_BE_CraftingTable.git/recipe"
+)
+
+type DatasetDTO struct {
+	Name     string `json:"name"`
+	Elements int    `json:"elements"`
+	Default  bool   `json:"default"`
+	Version  string `json:"version"`
+}
+
+// datasets holds every recipe file found next to recipes.json, keyed by the
+// name clients pass as ?dataset=.
+var datasets = make(map[string]map[string]recipe.Recipe)
+
+// datasetVersions holds a hash of every dataset file as it was loaded, so
+// cached results never outlive the data they were computed from.
+var datasetVersions = make(map[string]string)
+
+// datasetsMu guards datasets, datasetVersions, catalogs and aliases, which
+// are replaced as a whole when the data is reloaded. Running searches keep
+// the recipes they started with.
+var datasetsMu sync.RWMutex
+
+// dataFile is the path of a file in config.DataDir.
+func dataFile(name string) string {
+	return filepath.Join(config.DataDir, name)
+}
+
+// loadDatasets reads every dataset file in config.DataDir and replaces the
+// ones loaded before. It fails, keeping the old ones, only when recipes.json
+// can't be read.
+func loadDatasets() error {
+	loaded := make(map[string]map[string]recipe.Recipe)
+	versions := make(map[string]string)
+	load := func(name, file string) error {
+		start := time.Now()
+		recipes, err := recipe.ReadJson(file)
+		if err != nil {
+			return err
+		}
+		datasetLoadSeconds.WithLabelValues(name).Set(time.Since(start).Seconds())
+		loaded[name] = recipes
+		versions[name] = fileVersion(file)
+		return nil
+	}
+
+	if err := load(recipe.DefaultDataset, dataFile(recipe.DatasetFile(recipe.DefaultDataset))); err != nil {
+		return err
+	}
+	files, _ := filepath.Glob(dataFile(recipe.DatasetFile("*")))
+	for _, file := range files {
+		name := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(file), "recipes_"), ".json")
+		if err := load(name, file); err != nil {
+			slog.Warn("skipping dataset", "dataset", name, "err", err)
+			continue
+		}
+		slog.Info("loaded dataset", "dataset", name, "elements", len(loaded[name]))
+	}
+
+	loadedCatalogs := make(map[string]*catalog, len(loaded))
+	for name, recipes := range loaded {
+		loadedCatalogs[name] = newCatalog(recipes)
+	}
+
+	datasetsMu.Lock()
+	defer datasetsMu.Unlock()
+	datasets, datasetVersions, catalogs = loaded, versions, loadedCatalogs
+	recipe.RecipeMap = loaded[recipe.DefaultDataset]
+	return nil
+}
+
+// datasetNames returns the names of the loaded datasets, sorted.
+func datasetNames() []string {
+	datasetsMu.RLock()
+	defer datasetsMu.RUnlock()
+	names := make([]string, 0, len(datasets))
+	for name := range datasets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func datasetVersion(name string) string {
+	datasetsMu.RLock()
+	defer datasetsMu.RUnlock()
+	return datasetVersions[name]
+}
+
+func catalogFor(name string) *catalog {
+	datasetsMu.RLock()
+	defer datasetsMu.RUnlock()
+	return catalogs[name]
+}
+
+func fileVersion(file string) string {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return ""
+	}
+	return contentHash(data)
+}
+
+// contentHash is the short hash used for dataset versions and ETags.
+func contentHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8])
+}
+
+// datasetFor returns the dataset a request asked for, falling back to the
+// default one when no dataset parameter is given.
+func datasetFor(r *http.Request) (string, map[string]recipe.Recipe, *APIError) {
+	return datasetByName(r.URL.Query().Get("dataset"))
+}
+
+func datasetByName(name string) (string, map[string]recipe.Recipe, *APIError) {
+	if name == "" {
+		name = recipe.DefaultDataset
+	}
+	datasetsMu.RLock()
+	recipes, ok := datasets[name]
+	datasetsMu.RUnlock()
+	if !ok {
+		return "", nil, unknownDataset(name)
+	}
+	return name, recipes, nil
+}
+
+func datasetDTO(name string) DatasetDTO {
+	datasetsMu.RLock()
+	defer datasetsMu.RUnlock()
+	return DatasetDTO{
+		Name:     name,
+		Elements: len(datasets[name]),
+		Default:  name == recipe.DefaultDataset,
+		Version:  datasetVersions[name],
+	}
+}
+
+func datasetsHandler(w http.ResponseWriter, r *http.Request) {
+	names := datasetNames()
+	list := make([]DatasetDTO, 0, len(names))
+	for _, name := range names {
+		list = append(list, datasetDTO(name))
+	}
+	writeJSON(w, list)
+}
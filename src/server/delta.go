@@ -0,0 +1,166 @@
+package server
+
+import (
+	"sort"
+
+	recipe "github.com/Henshou/Tubes2_BE_CraftingTable.git/recipe"
+)
+
+const (
+	StreamModeTree  = "tree"
+	StreamModeDelta = "delta"
+
+	DeltaNodeAdded      = "node-added"
+	DeltaRecipeAttached = "recipe-attached"
+	DeltaRecipePruned   = "recipe-pruned"
+	DeltaNodePruned     = "node-pruned"
+	DeltaCounters       = "counters"
+	DeltaDone           = "done"
+)
+
+type SearchCounters struct {
+	TimeTaken    int64 `json:"timeTaken"` // ms
+	NodesVisited int   `json:"nodesVisited"`
+	RecipesFound int   `json:"recipesFound"`
+}
+
+// SearchTotals are the fields of a TreeResponse besides the tree.
+type SearchTotals struct {
+	TimeTaken    int64  `json:"timeTaken"` // ms
+	NodesVisited int    `json:"nodesVisited"`
+	RecipesFound int    `json:"recipesFound"`
+	MethodUsed   string `json:"methodUsed"`
+	TimedOut     bool   `json:"timedOut,omitempty"`
+}
+
+func (t *SearchTotals) counters() SearchCounters {
+	return SearchCounters{TimeTaken: t.TimeTaken, NodesVisited: t.NodesVisited, RecipesFound: t.RecipesFound}
+}
+
+// DeltaEvent is one change to the tree of a streaming search in delta mode.
+// Node and recipe ids are stable for the whole stream and start at 1; the
+// root is always node 1.
+//
+//   - node-added: ID, Name
+//   - recipe-attached: ID of the recipe, Parent node, Inputs node ids
+//   - recipe-pruned: ID of the recipe
+//   - node-pruned: ID of the node
+//   - counters: Counters
+//   - done: Totals
+type DeltaEvent struct {
+	Op       string          `json:"op"`
+	ID       int             `json:"id,omitempty"`
+	Name     string          `json:"name,omitempty"`
+	Parent   int             `json:"parent,omitempty"`
+	Inputs   []int           `json:"inputs,omitempty"`
+	Counters *SearchCounters `json:"counters,omitempty"`
+	Totals   *SearchTotals   `json:"totals,omitempty"`
+}
+
+// deltaTracker remembers which parts of a search tree a client has already
+// been told about.
+type deltaTracker struct {
+	nextID   int
+	nodes    map[*recipe.RecipeTreeNode]int
+	recipes  map[*recipe.RecipeTreeNode]int // keyed by a recipe's first input
+	counters SearchCounters
+}
+
+func newDeltaTracker() *deltaTracker {
+	return &deltaTracker{
+		nodes:   make(map[*recipe.RecipeTreeNode]int),
+		recipes: make(map[*recipe.RecipeTreeNode]int),
+	}
+}
+
+func (t *deltaTracker) id() int {
+	t.nextID++
+	return t.nextID
+}
+
+// diff returns the events for every node and recipe under root that wasn't
+// reported yet.
+func (t *deltaTracker) diff(root *recipe.RecipeTreeNode) []DeltaEvent {
+	var events []DeltaEvent
+	if _, ok := t.nodes[root]; !ok {
+		t.nodes[root] = t.id()
+		events = append(events, DeltaEvent{Op: DeltaNodeAdded, ID: t.nodes[root], Name: root.Name})
+	}
+
+	var walk func(node *recipe.RecipeTreeNode)
+	walk = func(node *recipe.RecipeTreeNode) {
+		for _, group := range node.Children {
+			if len(group) == 0 {
+				continue
+			}
+			if _, ok := t.recipes[group[0]]; !ok {
+				inputs := make([]int, 0, len(group))
+				for _, child := range group {
+					if _, ok := t.nodes[child]; !ok {
+						t.nodes[child] = t.id()
+						events = append(events, DeltaEvent{Op: DeltaNodeAdded, ID: t.nodes[child], Name: child.Name})
+					}
+					inputs = append(inputs, t.nodes[child])
+				}
+				t.recipes[group[0]] = t.id()
+				events = append(events, DeltaEvent{Op: DeltaRecipeAttached, ID: t.recipes[group[0]], Parent: t.nodes[node], Inputs: inputs})
+			}
+			for _, child := range group {
+				walk(child)
+			}
+		}
+	}
+	walk(root)
+	return events
+}
+
+// update returns a counters event if any counter changed since the last one.
+func (t *deltaTracker) update(counters SearchCounters) []DeltaEvent {
+	if counters.NodesVisited == t.counters.NodesVisited && counters.RecipesFound == t.counters.RecipesFound {
+		return nil
+	}
+	t.counters = counters
+	return []DeltaEvent{{Op: DeltaCounters, Counters: &counters}}
+}
+
+// pruned returns the events for every reported recipe and node that is no
+// longer part of the tree under root.
+func (t *deltaTracker) pruned(root *recipe.RecipeTreeNode) []DeltaEvent {
+	liveNodes := map[*recipe.RecipeTreeNode]bool{root: true}
+	liveRecipes := make(map[*recipe.RecipeTreeNode]bool)
+	var walk func(node *recipe.RecipeTreeNode)
+	walk = func(node *recipe.RecipeTreeNode) {
+		for _, group := range node.Children {
+			if len(group) == 0 {
+				continue
+			}
+			liveRecipes[group[0]] = true
+			for _, child := range group {
+				liveNodes[child] = true
+				walk(child)
+			}
+		}
+	}
+	walk(root)
+
+	var events []DeltaEvent
+	for key, id := range t.recipes {
+		if !liveRecipes[key] {
+			events = append(events, DeltaEvent{Op: DeltaRecipePruned, ID: id})
+			delete(t.recipes, key)
+		}
+	}
+	for node, id := range t.nodes {
+		if !liveNodes[node] {
+			events = append(events, DeltaEvent{Op: DeltaNodePruned, ID: id})
+			delete(t.nodes, node)
+		}
+	}
+	sort.Slice(events, func(i, j int) bool {
+		if events[i].Op != events[j].Op {
+			return events[i].Op == DeltaRecipePruned
+		}
+		return events[i].ID < events[j].ID
+	})
+	return events
+}
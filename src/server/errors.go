@@ -0,0 +1,110 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	CodeMissingParameter     = "missing_parameter"
+	CodeInvalidParameter     = "invalid_parameter"
+	CodeUnknownDataset       = "unknown_dataset"
+	CodeUnknownElement       = "unknown_element"
+	CodeNotImplemented       = "not_implemented"
+	CodeStreamingUnsupported = "streaming_unsupported"
+	CodeNoSearch             = "no_search"
+	CodeSearchRunning        = "search_running"
+	CodeStreamExpired        = "stream_expired"
+	CodeMethodNotAllowed     = "method_not_allowed"
+	CodeInvalidBody          = "invalid_body"
+	CodeUnknownJob           = "unknown_job"
+	CodeQueueFull            = "queue_full"
+	CodeTreeTooLarge         = "tree_too_large"
+	CodeShuttingDown         = "shutting_down"
+	CodeLoading              = "loading"
+	CodeRateLimited          = "rate_limited"
+	CodeTooManySearches      = "too_many_searches"
+	CodeQueryTooComplex      = "query_too_complex"
+	CodeUnauthorized         = "unauthorized"
+	CodeForbidden            = "forbidden"
+	CodeScrapeRunning        = "scrape_running"
+	CodeInternal             = "internal_error"
+)
+
+// APIError is the body of every error response, wrapped as {"error": ...}.
+type APIError struct {
+	Status  int         `json:"-"`
+	Code    string      `json:"code"`
+	Message string      `json:"message"`
+	Details interface{} `json:"details,omitempty"`
+	// RetryAfter is sent as the Retry-After header when it is set.
+	RetryAfter time.Duration `json:"-"`
+}
+
+type ErrorResponse struct {
+	Error *APIError `json:"error"`
+}
+
+type ParameterDetails struct {
+	Parameter string      `json:"parameter"`
+	Value     string      `json:"value,omitempty"`
+	Min       interface{} `json:"min,omitempty"`
+	Max       interface{} `json:"max,omitempty"`
+	Allowed   []string    `json:"allowed,omitempty"`
+}
+
+type UnknownElementDetails struct {
+	Name        string   `json:"name"`
+	Suggestions []string `json:"suggestions"`
+}
+
+func (e *APIError) Error() string {
+	return e.Message
+}
+
+func missingParameter(name string) *APIError {
+	return &APIError{
+		Status:  http.StatusBadRequest,
+		Code:    CodeMissingParameter,
+		Message: fmt.Sprintf("missing %s", name),
+		Details: ParameterDetails{Parameter: name},
+	}
+}
+
+func invalidParameter(details ParameterDetails, format string, args ...interface{}) *APIError {
+	return &APIError{
+		Status:  http.StatusBadRequest,
+		Code:    CodeInvalidParameter,
+		Message: fmt.Sprintf(format, args...),
+		Details: details,
+	}
+}
+
+func unknownDataset(name string) *APIError {
+	allowed := datasetNames()
+	return &APIError{
+		Status:  http.StatusBadRequest,
+		Code:    CodeUnknownDataset,
+		Message: fmt.Sprintf("unknown dataset %q", name),
+		Details: ParameterDetails{Parameter: "dataset", Value: name, Allowed: allowed},
+	}
+}
+
+func writeError(w http.ResponseWriter, err *APIError) {
+	if err.Status == 0 {
+		err.Status = http.StatusInternalServerError
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(err.RetryAfter.Seconds()))))
+	}
+	w.WriteHeader(err.Status)
+	if encodeErr := json.NewEncoder(w).Encode(ErrorResponse{Error: err}); encodeErr != nil {
+		slog.Debug("cannot write error response", "err", encodeErr)
+	}
+}
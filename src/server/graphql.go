@@ -0,0 +1,441 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+
+	graphql "github.com/graph-gophers/graphql-go"
+
+	recipe "github.com/Henshou/Tubes2_BE_CraftingTable.git/recipe"
+)
+
+const (
+	// MaxGraphQLDepth bounds how deeply a query may nest. Every level of
+	// SearchResult.tree takes two, so deep trees are read through nodes.
+	MaxGraphQLDepth = 10
+	// MaxGraphQLObjects bounds how many elements, recipes and tree nodes one
+	// query may return in total. Lists fan out, so depth alone doesn't keep
+	// a query cheap.
+	MaxGraphQLObjects = 10000
+	// MaxGraphQLProducts is the largest page of Element.products.
+	MaxGraphQLProducts = 100
+	// MaxGraphQLSearches is how many search fields one query may run.
+	MaxGraphQLSearches = 10
+)
+
+const graphQLSchema = `
+schema {
+	query: Query
+}
+
+type Query {
+	"An element of a dataset; names are resolved like ?target=."
+	element(name: String!, dataset: String): Element!
+	"Runs a search, or returns its cached result, like /api/search."
+	search(target: String!, method: String!, count: Int, depth: Int, dataset: String, timeout: String): SearchResult!
+}
+
+type Element {
+	name: String!
+	tier: Int!
+	imageUrl: String!
+	pageUrl: String
+	description: String
+	categories: [String!]!
+	pack: String
+	"True for the elements every recipe ends in."
+	isBase: Boolean!
+	"The ways to make the element directly."
+	recipes: [Recipe!]!
+	"A page of the elements this one is an ingredient of, by name."
+	products(limit: Int = 50, offset: Int = 0): [Element!]!
+	"How many elements this one is an ingredient of."
+	productCount: Int!
+}
+
+type Recipe {
+	ingredients: [Element!]!
+}
+
+type SearchResult {
+	"The tree, as deep as the query nests; each level takes two levels of the query. Read nodes for the whole tree."
+	tree: TreeNode!
+	"Every node of the tree, root first, with its recipes as lists of node ids."
+	nodes: [FlatTreeNode!]!
+	"Milliseconds the search took."
+	timeTaken: Int!
+	nodesVisited: Int!
+	"Complete recipes in the tree, down to base elements."
+	recipesFound: Int!
+	methodUsed: String!
+	timedOut: Boolean!
+}
+
+type TreeNode {
+	name: String!
+	element: Element!
+	recipes: [TreeRecipe!]!
+}
+
+type TreeRecipe {
+	inputs: [TreeNode!]!
+}
+
+type FlatTreeNode {
+	id: Int!
+	name: String!
+	element: Element!
+	"The ids of the inputs of each recipe."
+	recipes: [[Int!]!]!
+}
+`
+
+var graphQL = graphql.MustParseSchema(graphQLSchema, &graphQLResolver{},
+	graphql.MaxDepth(MaxGraphQLDepth))
+
+// GraphQLRequest is the body of a POST to /api/graphql. GET takes the same
+// fields as query parameters, with variables JSON-encoded.
+type GraphQLRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName,omitempty"`
+	Variables     map[string]interface{} `json:"variables,omitempty"`
+}
+
+type GraphQLError struct {
+	Message    string                 `json:"message"`
+	Path       []interface{}          `json:"path,omitempty"`
+	Extensions map[string]interface{} `json:"extensions,omitempty"`
+}
+
+type GraphQLResponse struct {
+	Data   json.RawMessage `json:"data,omitempty"`
+	Errors []GraphQLError  `json:"errors,omitempty"`
+}
+
+// graphQLQuery is the state of one query, shared by its resolvers.
+type graphQLQuery struct {
+	client   string
+	searches atomic.Int32
+	objects  atomic.Int32
+	// slots keeps the query from running more searches at once than its
+	// client may run in total.
+	slots chan struct{}
+}
+
+type graphQLQueryKey struct{}
+
+// spendObjects counts n more objects against the query's MaxGraphQLObjects.
+func spendObjects(ctx context.Context, n int) error {
+	q := ctx.Value(graphQLQueryKey{}).(*graphQLQuery)
+	if q.objects.Add(int32(n)) > MaxGraphQLObjects {
+		return &APIError{Code: CodeQueryTooComplex, Message: fmt.Sprintf("a query can return at most %d elements, recipes and tree nodes", MaxGraphQLObjects)}
+	}
+	return nil
+}
+
+// Extensions puts the code and details of an error into GraphQL errors.
+func (e *APIError) Extensions() map[string]interface{} {
+	ext := map[string]interface{}{"code": e.Code}
+	if e.Details != nil {
+		ext["details"] = e.Details
+	}
+	return ext
+}
+
+func graphQLHandler(w http.ResponseWriter, r *http.Request) {
+	var req GraphQLRequest
+	switch r.Method {
+	case http.MethodGet:
+		query := r.URL.Query()
+		req.Query, req.OperationName = query.Get("query"), query.Get("operationName")
+		if vars := query.Get("variables"); vars != "" {
+			if err := json.Unmarshal([]byte(vars), &req.Variables); err != nil {
+				writeError(w, invalidParameter(ParameterDetails{Parameter: "variables", Value: vars}, "variables must be a JSON object"))
+				return
+			}
+		}
+	case http.MethodPost:
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, &APIError{Status: http.StatusBadRequest, Code: CodeInvalidBody, Message: "invalid JSON body: " + err.Error()})
+			return
+		}
+	default:
+		writeError(w, &APIError{Status: http.StatusMethodNotAllowed, Code: CodeMethodNotAllowed, Message: "use GET or POST"})
+		return
+	}
+	if req.Query == "" {
+		writeError(w, missingParameter("query"))
+		return
+	}
+
+	q := &graphQLQuery{client: clientID(r), slots: make(chan struct{}, clientSearchSlots(MaxGraphQLSearches))}
+	ctx := context.WithValue(r.Context(), graphQLQueryKey{}, q)
+	res := graphQL.Exec(ctx, req.Query, req.OperationName, req.Variables)
+
+	resp := GraphQLResponse{Data: res.Data}
+	overBudget := false
+	for _, err := range res.Errors {
+		// Every list resolved after the budget ran out fails the same way;
+		// one error says it all.
+		if err.Extensions["code"] == CodeQueryTooComplex {
+			if overBudget {
+				continue
+			}
+			overBudget = true
+		}
+		resp.Errors = append(resp.Errors, GraphQLError{Message: err.Message, Path: err.Path, Extensions: err.Extensions})
+	}
+	if len(resp.Errors) > 0 {
+		requestLogger(r).Debug("graphql errors", "errors", len(resp.Errors), "first", resp.Errors[0].Message)
+	}
+	writeJSON(w, resp)
+}
+
+type graphQLResolver struct{}
+
+// graphQLDataset is the dataset a field was resolved in. Nested fields keep
+// using it, so one query never mixes data from before and after a reload.
+type graphQLDataset struct {
+	name    string
+	recipes map[string]recipe.Recipe
+	catalog *catalog
+}
+
+func graphQLDatasetByName(name string) (*graphQLDataset, error) {
+	name, recipes, apiErr := datasetByName(name)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	return &graphQLDataset{name: name, recipes: recipes, catalog: catalogFor(name)}, nil
+}
+
+func (ds *graphQLDataset) element(name string) *elementResolver {
+	el, ok := ds.recipes[name]
+	if !ok {
+		el = recipe.Recipe{Name: name}
+	}
+	return &elementResolver{ds: ds, el: el}
+}
+
+func (*graphQLResolver) Element(args struct {
+	Name    string
+	Dataset *string
+}) (*elementResolver, error) {
+	ds, err := graphQLDatasetByName(deref(args.Dataset))
+	if err != nil {
+		return nil, err
+	}
+	name, apiErr := lookupElement(ds.name, args.Name)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	return ds.element(name), nil
+}
+
+func (*graphQLResolver) Search(ctx context.Context, args struct {
+	Target  string
+	Method  string
+	Count   *int32
+	Depth   *int32
+	Dataset *string
+	Timeout *string
+}) (*searchResultResolver, error) {
+	q := ctx.Value(graphQLQueryKey{}).(*graphQLQuery)
+	if q.searches.Add(1) > MaxGraphQLSearches {
+		return nil, &APIError{Code: CodeTooManySearches, Message: fmt.Sprintf("a query can run at most %d searches", MaxGraphQLSearches)}
+	}
+
+	req := SearchRequest{Dataset: deref(args.Dataset), Target: args.Target, Method: args.Method, Timeout: deref(args.Timeout)}
+	if args.Count != nil {
+		req.Count = int(*args.Count)
+	}
+	if args.Depth != nil {
+		req.Depth = int(*args.Depth)
+	}
+	p, apiErr := parseSearchParams(req.query(), "")
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	p.Logger, p.Client = contextLogger(ctx), q.client
+
+	q.slots <- struct{}{}
+	cached, _, apiErr := cachedSearch(p)
+	<-q.slots
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	var res TreeResponse
+	if err := json.Unmarshal(cached.body, &res); err != nil {
+		return nil, &APIError{Code: CodeInternal, Message: "json decode error"}
+	}
+	return &searchResultResolver{ds: &graphQLDataset{name: p.Dataset, recipes: p.Recipes, catalog: catalogFor(p.Dataset)}, res: res}, nil
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+func optional(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+type elementResolver struct {
+	ds *graphQLDataset
+	el recipe.Recipe
+}
+
+func (e *elementResolver) Name() string         { return e.el.Name }
+func (e *elementResolver) Tier() int32          { return int32(e.el.Tier) }
+func (e *elementResolver) ImageURL() string     { return e.el.ImageURL }
+func (e *elementResolver) PageURL() *string     { return optional(e.el.PageURL) }
+func (e *elementResolver) Description() *string { return optional(e.el.Description) }
+func (e *elementResolver) Pack() *string        { return optional(e.el.Pack) }
+
+func (e *elementResolver) Categories() []string {
+	if e.el.Categories == nil {
+		return []string{}
+	}
+	return e.el.Categories
+}
+
+func (e *elementResolver) IsBase() bool {
+	return recipe.IsBaseElement(e.el.Name, e.ds.recipes)
+}
+
+func (e *elementResolver) Recipes(ctx context.Context) ([]*recipeResolver, error) {
+	list := make([]*recipeResolver, 0, len(e.el.Recipes))
+	for _, ingredients := range e.el.Recipes {
+		if len(ingredients) > 0 {
+			list = append(list, &recipeResolver{ds: e.ds, ingredients: ingredients})
+		}
+	}
+	return list, spendObjects(ctx, len(list))
+}
+
+func (e *elementResolver) Products(ctx context.Context, args struct {
+	Limit  int32
+	Offset int32
+}) ([]*elementResolver, error) {
+	if args.Limit < 1 || args.Limit > MaxGraphQLProducts {
+		return nil, invalidParameter(ParameterDetails{Parameter: "limit", Value: fmt.Sprint(args.Limit), Min: 1, Max: MaxGraphQLProducts},
+			"limit must be between 1 and %d", MaxGraphQLProducts)
+	}
+	if args.Offset < 0 {
+		return nil, invalidParameter(ParameterDetails{Parameter: "offset", Value: fmt.Sprint(args.Offset), Min: 0}, "offset must not be negative")
+	}
+	names := e.ds.catalog.products[e.el.Name]
+	names = names[min(int(args.Offset), len(names)):]
+	names = names[:min(int(args.Limit), len(names))]
+	list := make([]*elementResolver, 0, len(names))
+	for _, name := range names {
+		list = append(list, e.ds.element(name))
+	}
+	return list, spendObjects(ctx, len(list))
+}
+
+func (e *elementResolver) ProductCount() int32 {
+	return int32(len(e.ds.catalog.products[e.el.Name]))
+}
+
+type recipeResolver struct {
+	ds          *graphQLDataset
+	ingredients []string
+}
+
+func (r *recipeResolver) Ingredients(ctx context.Context) ([]*elementResolver, error) {
+	list := make([]*elementResolver, 0, len(r.ingredients))
+	for _, name := range r.ingredients {
+		list = append(list, r.ds.element(name))
+	}
+	return list, spendObjects(ctx, len(list))
+}
+
+type searchResultResolver struct {
+	ds  *graphQLDataset
+	res TreeResponse
+}
+
+func (s *searchResultResolver) Tree() *treeNodeResolver {
+	return &treeNodeResolver{ds: s.ds, node: s.res.Tree}
+}
+
+func (s *searchResultResolver) Nodes(ctx context.Context) ([]*flatNodeResolver, error) {
+	var list []*flatNodeResolver
+	var flatten func(node NodeDTO) int32
+	flatten = func(node NodeDTO) int32 {
+		n := &flatNodeResolver{ds: s.ds, id: int32(len(list)), name: node.Name, recipes: make([][]int32, 0, len(node.Recipes))}
+		list = append(list, n)
+		for _, r := range node.Recipes {
+			inputs := make([]int32, 0, len(r.Inputs))
+			for _, input := range r.Inputs {
+				inputs = append(inputs, flatten(input))
+			}
+			n.recipes = append(n.recipes, inputs)
+		}
+		return n.id
+	}
+	flatten(s.res.Tree)
+	return list, spendObjects(ctx, len(list))
+}
+
+func (s *searchResultResolver) TimeTaken() int32    { return int32(s.res.TimeTaken) }
+func (s *searchResultResolver) NodesVisited() int32 { return int32(s.res.NodesVisited) }
+func (s *searchResultResolver) RecipesFound() int32 { return int32(s.res.RecipesFound) }
+func (s *searchResultResolver) MethodUsed() string  { return s.res.MethodUsed }
+func (s *searchResultResolver) TimedOut() bool      { return s.res.TimedOut }
+
+type treeNodeResolver struct {
+	ds   *graphQLDataset
+	node NodeDTO
+}
+
+func (n *treeNodeResolver) Name() string { return n.node.Name }
+
+func (n *treeNodeResolver) Element() *elementResolver {
+	return n.ds.element(n.node.Name)
+}
+
+func (n *treeNodeResolver) Recipes(ctx context.Context) ([]*treeRecipeResolver, error) {
+	list := make([]*treeRecipeResolver, 0, len(n.node.Recipes))
+	for _, r := range n.node.Recipes {
+		list = append(list, &treeRecipeResolver{ds: n.ds, recipe: r})
+	}
+	return list, spendObjects(ctx, len(list))
+}
+
+type treeRecipeResolver struct {
+	ds     *graphQLDataset
+	recipe RecipeDTO
+}
+
+func (r *treeRecipeResolver) Inputs(ctx context.Context) ([]*treeNodeResolver, error) {
+	list := make([]*treeNodeResolver, 0, len(r.recipe.Inputs))
+	for _, input := range r.recipe.Inputs {
+		list = append(list, &treeNodeResolver{ds: r.ds, node: input})
+	}
+	return list, spendObjects(ctx, len(list))
+}
+
+type flatNodeResolver struct {
+	ds      *graphQLDataset
+	id      int32
+	name    string
+	recipes [][]int32
+}
+
+func (n *flatNodeResolver) ID() int32          { return n.id }
+func (n *flatNodeResolver) Name() string       { return n.name }
+func (n *flatNodeResolver) Recipes() [][]int32 { return n.recipes }
+
+func (n *flatNodeResolver) Element() *elementResolver {
+	return n.ds.element(n.name)
+}
@@ -0,0 +1,187 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+type HealthResponse struct {
+	Status string `json:"status"`
+}
+
+type ReadyResponse struct {
+	Status   string       `json:"status"`
+	Datasets []DatasetDTO `json:"datasets,omitempty"`
+}
+
+var (
+	// ready is set once the datasets are loaded and cleared when the server
+	// starts shutting down.
+	ready atomic.Bool
+	// loaded is set once the datasets are loaded and stays set.
+	loaded atomic.Bool
+	// draining is closed when the server starts shutting down.
+	draining = make(chan struct{})
+)
+
+// running holds every search that hasn't returned yet, so they can be
+// cancelled on shutdown.
+var running = struct {
+	sync.Mutex
+	searches map[*search]bool
+}{searches: make(map[*search]bool)}
+
+// wsConns holds the open WebSocket connections, which http.Server.Shutdown
+// doesn't track.
+var wsConns = struct {
+	sync.Mutex
+	conns map[*websocket.Conn]bool
+}{conns: make(map[*websocket.Conn]bool)}
+
+func trackSearch(s *search) {
+	running.Lock()
+	defer running.Unlock()
+	running.searches[s] = true
+}
+
+func untrackSearch(s *search) {
+	running.Lock()
+	defer running.Unlock()
+	delete(running.searches, s)
+}
+
+func cancelSearches() int {
+	running.Lock()
+	defer running.Unlock()
+	for s := range running.searches {
+		s.Cancel()
+	}
+	return len(running.searches)
+}
+
+func trackConn(conn *websocket.Conn) {
+	wsConns.Lock()
+	defer wsConns.Unlock()
+	wsConns.conns[conn] = true
+}
+
+func untrackConn(conn *websocket.Conn) {
+	wsConns.Lock()
+	defer wsConns.Unlock()
+	delete(wsConns.conns, conn)
+}
+
+func closeConns() {
+	wsConns.Lock()
+	defer wsConns.Unlock()
+	msg := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down")
+	for conn := range wsConns.conns {
+		conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+		conn.Close()
+	}
+}
+
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, HealthResponse{Status: "ok"})
+}
+
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+	if !ready.Load() {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		status := "loading"
+		select {
+		case <-draining:
+			status = "draining"
+		default:
+		}
+		writeJSON(w, ReadyResponse{Status: status})
+		return
+	}
+
+	resp := ReadyResponse{Status: "ready"}
+	for _, name := range datasetNames() {
+		resp.Datasets = append(resp.Datasets, datasetDTO(name))
+	}
+	writeJSON(w, resp)
+}
+
+// LoadingRetryAfter is the Retry-After sent while the datasets load.
+const LoadingRetryAfter = 5 * time.Second
+
+// withLoading answers 503 to /api requests until the datasets are loaded.
+// The health probes and metrics are served from the start.
+func withLoading(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !loaded.Load() && strings.HasPrefix(r.URL.Path, "/api/") && r.URL.Path != "/api/openapi.json" {
+			apiErr := &APIError{Status: http.StatusServiceUnavailable, Code: CodeLoading, Message: "the datasets are still loading", RetryAfter: LoadingRetryAfter}
+			writeError(w, apiErr)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// load runs prepare, then loads the datasets and aliases and marks the
+// server ready. It runs while the server is already listening, so probes
+// can tell a slow start from a dead process.
+func load(prepare func() error) {
+	if prepare != nil {
+		if err := prepare(); err != nil {
+			fatal("cannot prepare the datasets", err)
+		}
+	}
+	if err := loadDatasets(); err != nil {
+		fatal("cannot load recipes.json", err)
+	}
+	if err := loadAliases(dataFile("aliases.json")); err != nil {
+		fatal("cannot load aliases.json", err)
+	}
+	loaded.Store(true)
+	select {
+	case <-draining:
+	default:
+		ready.Store(true)
+	}
+	slog.Info("datasets loaded", "datasets", len(datasetNames()))
+}
+
+// serve runs srv until SIGINT or SIGTERM, then shuts it down: it stops
+// reporting ready, cancels the running searches so their streams end, and
+// waits up to config.ShutdownTimeout for the open requests to finish.
+func serve(srv *http.Server) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	srv.RegisterOnShutdown(closeConns)
+	errc := make(chan error, 1)
+	go func() { errc <- srv.ListenAndServe() }()
+
+	select {
+	case err := <-errc:
+		fatal("server failed", err)
+	case <-ctx.Done():
+	}
+
+	slog.Info("shutting down")
+	ready.Store(false)
+	close(draining)
+	slog.Info("cancelled running searches", "searches", cancelSearches())
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), config.ShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		slog.Warn("shutdown did not finish", "err", err)
+	}
+	slog.Info("server stopped")
+}
@@ -0,0 +1,90 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash/fnv"
+	"html"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ImageDir is the directory the scraper mirrored element icons into,
+// relative to config.DataDir unless it is absolute.
+var ImageDir = "images"
+
+func imagePath(file string) string {
+	if filepath.IsAbs(ImageDir) {
+		return filepath.Join(ImageDir, file)
+	}
+	return filepath.Join(config.DataDir, ImageDir, file)
+}
+
+func imageHandler(w http.ResponseWriter, r *http.Request) {
+	dataset, recipes, apiErr := datasetFor(r)
+	if apiErr != nil {
+		writeError(w, apiErr)
+		return
+	}
+	name, apiErr := lookupElement(dataset, r.PathValue("element"))
+	if apiErr != nil {
+		writeError(w, apiErr)
+		return
+	}
+	el := recipes[name]
+
+	if el.ImageFile != "" {
+		file := filepath.Base(el.ImageFile)
+		f, err := os.Open(imagePath(file))
+		if err == nil {
+			defer f.Close()
+			if info, err := f.Stat(); err == nil {
+				// The file name is the content hash, so it never changes.
+				w.Header().Set("ETag", `"`+strings.TrimSuffix(file, filepath.Ext(file))+`"`)
+				w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+				http.ServeContent(w, r, file, info.ModTime(), f)
+				return
+			}
+		}
+	}
+
+	svg := placeholderSVG(el.Name)
+	sum := sha256.Sum256(svg)
+	w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:8])+`"`)
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	w.Header().Set("Content-Type", "image/svg+xml")
+	if match := r.Header.Get("If-None-Match"); match == w.Header().Get("ETag") {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Write(svg)
+}
+
+// placeholderSVG draws the initials of an element on a circle whose colour is
+// derived from its name, so the same element always gets the same icon.
+func placeholderSVG(name string) []byte {
+	return []byte(fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" width="64" height="64" viewBox="0 0 64 64">`+
+		`<circle cx="32" cy="32" r="30" fill="hsl(%d, 55%%, 55%%)"/>`+
+		`<text x="32" y="32" dy=".35em" text-anchor="middle" font-family="sans-serif" font-size="24" fill="#fff">%s</text>`+
+		`</svg>`, placeholderHue(name), html.EscapeString(initials(name))))
+}
+
+func placeholderHue(name string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	return h.Sum32() % 360
+}
+
+func initials(name string) string {
+	initials := ""
+	for _, word := range strings.Fields(name) {
+		initials += strings.ToUpper(string([]rune(word)[0]))
+		if len([]rune(initials)) == 2 {
+			break
+		}
+	}
+	return initials
+}
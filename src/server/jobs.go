@@ -0,0 +1,314 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobDone      = "done"
+	JobCancelled = "cancelled"
+
+	MaxQueuedJobs = 100
+)
+
+// SearchRequest is the JSON form of the search parameters, used where a
+// search is posted instead of passed in the query string.
+type SearchRequest struct {
+	Dataset string `json:"dataset,omitempty"`
+	Target  string `json:"target"`
+	Method  string `json:"method"`
+	Count   int    `json:"count,omitempty"`
+	Depth   int    `json:"depth,omitempty"`
+	Timeout string `json:"timeout,omitempty"`
+}
+
+func (req SearchRequest) query() url.Values {
+	query := url.Values{}
+	query.Set("dataset", req.Dataset)
+	query.Set("target", req.Target)
+	query.Set("method", req.Method)
+	query.Set("timeout", req.Timeout)
+	if req.Count != 0 {
+		query.Set("count", strconv.Itoa(req.Count))
+	}
+	if req.Depth != 0 {
+		query.Set("depth", strconv.Itoa(req.Depth))
+	}
+	return query
+}
+
+type JobDTO struct {
+	ID         string        `json:"id"`
+	Status     string        `json:"status"`
+	Dataset    string        `json:"dataset"`
+	Target     string        `json:"target"`
+	Method     string        `json:"method"`
+	Count      int           `json:"count"`
+	CreatedAt  time.Time     `json:"createdAt"`
+	StartedAt  *time.Time    `json:"startedAt,omitempty"`
+	FinishedAt *time.Time    `json:"finishedAt,omitempty"`
+	ExpiresAt  *time.Time    `json:"expiresAt,omitempty"`
+	Result     *TreeResponse `json:"result,omitempty"`
+}
+
+type JobListResponse struct {
+	Jobs []JobDTO `json:"jobs"`
+}
+
+type job struct {
+	ID     string
+	params searchParams
+
+	mu         sync.Mutex
+	status     string
+	search     *search
+	result     *TreeResponse
+	createdAt  time.Time
+	startedAt  time.Time
+	finishedAt time.Time
+}
+
+var jobs = struct {
+	sync.Mutex
+	byID  map[string]*job
+	queue chan *job
+}{byID: make(map[string]*job), queue: make(chan *job, MaxQueuedJobs)}
+
+func startJobWorkers(n int) {
+	for i := 0; i < n; i++ {
+		go func() {
+			for j := range jobs.queue {
+				j.run()
+			}
+		}()
+	}
+	go func() {
+		for range time.Tick(time.Minute) {
+			expireJobs()
+		}
+	}()
+}
+
+func expireJobs() {
+	jobs.Lock()
+	defer jobs.Unlock()
+	for id, j := range jobs.byID {
+		if j.expired() {
+			delete(jobs.byID, id)
+		}
+	}
+}
+
+func findJob(id string) (*job, *APIError) {
+	jobs.Lock()
+	j, ok := jobs.byID[id]
+	jobs.Unlock()
+	if !ok || j.expired() {
+		return nil, &APIError{Status: http.StatusNotFound, Code: CodeUnknownJob, Message: fmt.Sprintf("unknown job %q", id)}
+	}
+	return j, nil
+}
+
+func (j *job) run() {
+	j.mu.Lock()
+	if j.status != JobQueued {
+		j.mu.Unlock()
+		return
+	}
+	j.status = JobRunning
+	j.startedAt = time.Now()
+	// The job was counted against its client when it was queued, so it
+	// isn't refused now.
+	j.search, _ = startSearch(j.params, nil)
+	j.mu.Unlock()
+
+	j.params.Logger.Debug("job started")
+	result := j.search.result()
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.result = &result
+	j.finishedAt = time.Now()
+	if j.search.cancelled.Load() {
+		j.status = JobCancelled
+	} else {
+		j.status = JobDone
+	}
+	j.params.Logger.Debug("job finished", "status", j.status)
+}
+
+func (j *job) cancel() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	switch j.status {
+	case JobQueued:
+		j.status = JobCancelled
+		j.finishedAt = time.Now()
+		releaseSearch(j.params.Client)
+	case JobRunning:
+		j.search.Cancel()
+	}
+}
+
+func (j *job) expired() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return !j.finishedAt.IsZero() && time.Since(j.finishedAt) > config.JobRetention
+}
+
+// dto describes the job. A running job reports its partial tree when
+// withResult is set.
+func (j *job) dto(withResult bool) JobDTO {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	dto := JobDTO{
+		ID:        j.ID,
+		Status:    j.status,
+		Dataset:   j.params.Dataset,
+		Target:    j.params.Target,
+		Method:    j.params.Method,
+		Count:     j.params.Count,
+		CreatedAt: j.createdAt,
+	}
+	if !j.startedAt.IsZero() {
+		dto.StartedAt = &j.startedAt
+	}
+	if !j.finishedAt.IsZero() {
+		expires := j.finishedAt.Add(config.JobRetention)
+		dto.FinishedAt = &j.finishedAt
+		dto.ExpiresAt = &expires
+	}
+	if !withResult {
+		return dto
+	}
+	if j.result != nil {
+		dto.Result = j.result
+	} else if j.status == JobRunning {
+		partial := j.search.snapshot()
+		dto.Result = &partial
+	}
+	return dto
+}
+
+// snapshot describes a search that may still be running, with the tree as
+// it is so far.
+func (s *search) snapshot() TreeResponse {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.progress(s.root)
+}
+
+func jobsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		createJob(w, r)
+	case http.MethodGet:
+		listJobs(w)
+	default:
+		writeError(w, &APIError{Status: http.StatusMethodNotAllowed, Code: CodeMethodNotAllowed, Message: "use GET or POST"})
+	}
+}
+
+func createJob(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	var req SearchRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err == nil {
+		query = req.query()
+	} else if !errors.Is(err, io.EOF) {
+		writeError(w, &APIError{Status: http.StatusBadRequest, Code: CodeInvalidBody, Message: "invalid JSON body: " + err.Error()})
+		return
+	}
+
+	// Jobs may run longer than MaxTimeout, up to config.JobMaxTimeout.
+	timeout, apiErr := durationParam(query, "timeout", config.JobMaxTimeout, MinTimeout, config.JobMaxTimeout)
+	if apiErr != nil {
+		writeError(w, apiErr)
+		return
+	}
+	query.Del("timeout")
+	p, apiErr := parseSearchParams(query, "")
+	if apiErr != nil {
+		writeError(w, apiErr)
+		return
+	}
+	p.Timeout = timeout
+
+	// A job counts against its client while it is queued too, so one client
+	// can't fill the queue.
+	p.Client, p.Claimed = clientID(r), true
+	if apiErr := claimSearch(p.Client); apiErr != nil {
+		writeError(w, apiErr)
+		return
+	}
+
+	j := &job{ID: randomID(), params: p, status: JobQueued, createdAt: time.Now()}
+	j.params.Logger = requestLogger(r).With("job", j.ID)
+
+	select {
+	case jobs.queue <- j:
+	default:
+		releaseSearch(p.Client)
+		writeError(w, &APIError{Status: http.StatusServiceUnavailable, Code: CodeQueueFull, Message: "too many queued jobs, try again later"})
+		return
+	}
+	jobs.Lock()
+	jobs.byID[j.ID] = j
+	jobs.Unlock()
+	j.params.Logger.Debug("job queued", "method", p.Method, "target", p.Target)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/api/jobs/"+j.ID)
+	w.WriteHeader(http.StatusAccepted)
+	writeJSON(w, j.dto(false))
+}
+
+func listJobs(w http.ResponseWriter) {
+	jobs.Lock()
+	list := make([]*job, 0, len(jobs.byID))
+	for _, j := range jobs.byID {
+		list = append(list, j)
+	}
+	jobs.Unlock()
+
+	resp := JobListResponse{Jobs: make([]JobDTO, 0, len(list))}
+	for _, j := range list {
+		if !j.expired() {
+			resp.Jobs = append(resp.Jobs, j.dto(false))
+		}
+	}
+	sort.Slice(resp.Jobs, func(a, b int) bool {
+		return resp.Jobs[a].CreatedAt.Before(resp.Jobs[b].CreatedAt)
+	})
+	writeJSON(w, resp)
+}
+
+func jobHandler(w http.ResponseWriter, r *http.Request) {
+	j, apiErr := findJob(r.PathValue("id"))
+	if apiErr != nil {
+		writeError(w, apiErr)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, j.dto(true))
+	case http.MethodDelete:
+		j.cancel()
+		requestLogger(r).Info("job cancelled", "job", j.ID)
+		writeJSON(w, j.dto(false))
+	default:
+		writeError(w, &APIError{Status: http.StatusMethodNotAllowed, Code: CodeMethodNotAllowed, Message: "use GET or DELETE"})
+	}
+}
@@ -0,0 +1,136 @@
+package server
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BusyRetryAfter is the Retry-After sent to a client that already runs
+// config.ClientConcurrentSearches searches.
+const BusyRetryAfter = 5 * time.Second
+
+// bucket is the token bucket of one client. It holds up to config.RateBurst
+// tokens and refills at config.RateLimit tokens a second.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+var limits = struct {
+	sync.Mutex
+	buckets   map[string]*bucket
+	searches  map[string]int
+	lastSweep time.Time
+}{buckets: make(map[string]*bucket), searches: make(map[string]int)}
+
+// clientID identifies the caller for rate limiting: the name of its API key
+// when it sent a known one, its IP address otherwise.
+func clientID(r *http.Request) string {
+	if k, ok := keyFor(r); ok {
+		return "key:" + k.Name
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// take spends one token of client's bucket. When it's empty, take returns
+// how long until the next token.
+func take(client string, now time.Time) (bool, time.Duration) {
+	limits.Lock()
+	defer limits.Unlock()
+
+	rate, burst := float64(config.RateLimit), float64(config.RateBurst)
+	if now.Sub(limits.lastSweep) > time.Minute {
+		// A bucket that would have refilled is the same as no bucket.
+		for id, b := range limits.buckets {
+			if b.tokens+now.Sub(b.last).Seconds()*rate >= burst {
+				delete(limits.buckets, id)
+			}
+		}
+		limits.lastSweep = now
+	}
+
+	b, ok := limits.buckets[client]
+	if !ok {
+		b = &bucket{tokens: burst, last: now}
+		limits.buckets[client] = b
+	}
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+func tooManyRequests(retryAfter time.Duration, code, message string) *APIError {
+	return &APIError{Status: http.StatusTooManyRequests, Code: code, Message: message, RetryAfter: retryAfter}
+}
+
+// withRateLimit makes every client spend a token per /api request, and
+// answers 429 when its bucket is empty. Icons aren't counted: a tree loads
+// dozens at once and they are served from disk. config.RateLimit 0 turns it
+// off.
+func withRateLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limited := strings.HasPrefix(r.URL.Path, "/api/") && !strings.HasPrefix(r.URL.Path, "/api/images/")
+		if config.RateLimit > 0 && limited {
+			if ok, wait := take(clientID(r), time.Now()); !ok {
+				rejectedRequests.WithLabelValues(CodeRateLimited).Inc()
+				requestLogger(r).Info("rate limited", "client", clientID(r))
+				writeError(w, tooManyRequests(wait, CodeRateLimited, "too many requests, slow down"))
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// claimSearch counts a search of client, which may run at most
+// config.ClientConcurrentSearches at once. Searches beyond
+// config.MaxConcurrentSearches across all clients are queued by startSearch
+// instead. Searches without a client aren't counted.
+func claimSearch(client string) *APIError {
+	if config.ClientConcurrentSearches == 0 || client == "" {
+		return nil
+	}
+	limits.Lock()
+	defer limits.Unlock()
+	if limits.searches[client] >= config.ClientConcurrentSearches {
+		rejectedRequests.WithLabelValues(CodeTooManySearches).Inc()
+		return tooManyRequests(BusyRetryAfter, CodeTooManySearches,
+			"at most "+strconv.Itoa(config.ClientConcurrentSearches)+" searches may run at once, wait for one to finish")
+	}
+	limits.searches[client]++
+	return nil
+}
+
+func releaseSearch(client string) {
+	if config.ClientConcurrentSearches == 0 || client == "" {
+		return
+	}
+	limits.Lock()
+	defer limits.Unlock()
+	if limits.searches[client]--; limits.searches[client] <= 0 {
+		delete(limits.searches, client)
+	}
+}
+
+// clientSearchSlots is how many searches one request of a client should run
+// at once, so it doesn't run into config.ClientConcurrentSearches on its
+// own.
+func clientSearchSlots(want int) int {
+	if config.ClientConcurrentSearches > 0 {
+		return min(want, config.ClientConcurrentSearches)
+	}
+	return want
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+)
+
+type loggerKey struct{}
+
+// ConfigureLogging makes slog, and the log package through it, write at
+// cfg.LogLevel in cfg.LogFormat. Start calls it; main calls it earlier so the
+// scraper logs the same way.
+func ConfigureLogging(cfg Config) {
+	var level slog.Level
+	level.UnmarshalText([]byte(cfg.LogLevel))
+	opts := &slog.HandlerOptions{Level: level}
+
+	var handler slog.Handler = slog.NewTextHandler(os.Stderr, opts)
+	if cfg.LogFormat == "json" {
+		handler = slog.NewJSONHandler(os.Stderr, opts)
+	}
+	slog.SetDefault(slog.New(handler))
+}
+
+// fatal logs err and exits, for errors the server can't start with.
+func fatal(msg string, err error) {
+	slog.Error(msg, "err", err)
+	os.Exit(1)
+}
+
+func randomID() string {
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// withRequestLog gives every request an id, taken from X-Request-ID or made
+// up, and a logger that adds it to every line. The id is sent back in
+// X-Request-ID. Probes and metric scrapes are only logged at debug level.
+func withRequestLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if id == "" || len(id) > 64 {
+			id = randomID()
+		}
+		w.Header().Set("X-Request-ID", id)
+		logger := slog.With("request_id", id)
+
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), loggerKey{}, logger)))
+
+		level := slog.LevelInfo
+		switch r.URL.Path {
+		case "/healthz", "/readyz", "/metrics":
+			level = slog.LevelDebug
+		}
+		logger.Log(r.Context(), level, "request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", rec.code(),
+			"duration_ms", time.Since(start).Milliseconds())
+	})
+}
+
+// requestLogger is the logger of r, which carries its request id.
+func requestLogger(r *http.Request) *slog.Logger {
+	return contextLogger(r.Context())
+}
+
+func contextLogger(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
@@ -0,0 +1,176 @@
+package server
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	OutcomeCompleted = "completed"
+	OutcomeCancelled = "cancelled"
+	OutcomeTimedOut  = "timed_out"
+)
+
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "craftingtable_http_requests_total",
+		Help: "HTTP requests by route, method and status code.",
+	}, []string{"route", "method", "code"})
+	// Streamed searches stay open for minutes, hence the long tail.
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "craftingtable_http_request_duration_seconds",
+		Help:    "Time taken to answer HTTP requests, by route and method.",
+		Buckets: prometheus.ExponentialBuckets(0.005, 4, 9),
+	}, []string{"route", "method"})
+
+	searchesInFlight = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "craftingtable_searches_in_flight",
+		Help: "Searches currently running.",
+	})
+	searchesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "craftingtable_searches_total",
+		Help: "Finished searches by method and outcome (completed, cancelled or timed_out).",
+	}, []string{"method", "outcome"})
+	searchNodesVisited = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "craftingtable_search_nodes_visited",
+		Help:    "Nodes visited per search.",
+		Buckets: prometheus.ExponentialBuckets(10, 4, 10),
+	}, []string{"method"})
+	searchRecipesFound = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "craftingtable_search_recipes_found",
+		Help:    "Complete recipes found per search.",
+		Buckets: prometheus.ExponentialBuckets(1, 4, 8),
+	}, []string{"method"})
+
+	sseConnections = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "craftingtable_sse_connections",
+		Help: "Open Server-Sent Events connections.",
+	})
+
+	rejectedRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "craftingtable_rejected_requests_total",
+		Help: "Requests and searches refused for going over a limit, by error code.",
+	}, []string{"code"})
+
+	datasetLoadSeconds = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "craftingtable_dataset_load_seconds",
+		Help: "Time taken to load each dataset at startup.",
+	}, []string{"dataset"})
+)
+
+func init() {
+	promauto.NewCounterFunc(prometheus.CounterOpts{
+		Name: "craftingtable_result_cache_hits_total",
+		Help: "Searches answered from the result cache.",
+	}, func() float64 { return float64(results.hits.Load()) })
+	promauto.NewCounterFunc(prometheus.CounterOpts{
+		Name: "craftingtable_result_cache_misses_total",
+		Help: "Searches that had to run because the result cache didn't hold them.",
+	}, func() float64 { return float64(results.misses.Load()) })
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "craftingtable_result_cache_hit_ratio",
+		Help: "Share of searches answered from the result cache since startup.",
+	}, results.hitRatio)
+}
+
+func searchOutcome(totals SearchTotals, cancelled bool) string {
+	switch {
+	case totals.TimedOut:
+		return OutcomeTimedOut
+	case cancelled:
+		return OutcomeCancelled
+	}
+	return OutcomeCompleted
+}
+
+// observeSearch records a finished search.
+func observeSearch(totals SearchTotals, outcome string) {
+	searchesTotal.WithLabelValues(totals.MethodUsed, outcome).Inc()
+	searchNodesVisited.WithLabelValues(totals.MethodUsed).Observe(float64(totals.NodesVisited))
+	searchRecipesFound.WithLabelValues(totals.MethodUsed).Observe(float64(totals.RecipesFound))
+}
+
+// statusRecorder remembers the status code written through it. It passes
+// Flush and Hijack through, which SSE and WebSocket handlers need.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	return rec.ResponseWriter.Write(b)
+}
+
+func (rec *statusRecorder) Flush() {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	if flusher, ok := rec.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (rec *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusSwitchingProtocols
+	}
+	return http.NewResponseController(rec.ResponseWriter).Hijack()
+}
+
+func (rec *statusRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// code is the status sent, 200 if the handler wrote nothing.
+func (rec *statusRecorder) code() int {
+	if rec.status == 0 {
+		return http.StatusOK
+	}
+	return rec.status
+}
+
+// withMetrics counts every request and times it. Requests are labelled with
+// the ServeMux pattern that matched them, so /api/elements/{name} is one
+// route however many elements are asked for.
+func withMetrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		route := r.Pattern
+		if route == "" {
+			route = "other"
+		}
+		method := metricMethod(r.Method)
+		httpRequests.WithLabelValues(route, method, strconv.Itoa(rec.code())).Inc()
+		httpDuration.WithLabelValues(route, method).Observe(time.Since(start).Seconds())
+	})
+}
+
+// metricMethod is the method label of a request. Clients may send any
+// token as the method, so only the standard ones get their own label.
+func metricMethod(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return method
+	}
+	return "other"
+}
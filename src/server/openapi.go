@@ -0,0 +1,532 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	recipe "github.com/Henshou/Tubes2_BE_CraftingTable.git/recipe"
+)
+
+// The OpenAPI document is built from the table below and from the DTO types
+// themselves: every schema is generated by reflection from the Go type a
+// handler encodes, so adding a field to a DTO updates the spec. checkSpec
+// makes Start fail when a route or a method is registered without being
+// documented, or documented without being registered.
+
+// operation documents one method of a route. Body and the content of every
+// response are Go values whose types become the schemas.
+type operation struct {
+	Summary     string
+	Description string
+	Params      []param
+	Body        interface{}
+	Responses   map[int]response
+}
+
+type param struct {
+	Name        string
+	In          string
+	Description string
+	Required    bool
+	Schema      map[string]interface{}
+}
+
+// response lists the body of a response by content type. A nil value means
+// the body is not JSON and is described as a string.
+type response struct {
+	Description string
+	Content     map[string]interface{}
+}
+
+// route is a pattern registered by registerRoutes and the methods its
+// handler answers.
+type route struct {
+	pattern string
+	methods []string
+}
+
+// routeTable collects the routes registered on a ServeMux for checkSpec.
+type routeTable struct {
+	mux    *http.ServeMux
+	routes []route
+}
+
+// handleFunc registers h for pattern, answering any method but the listed
+// ones with 405. HEAD is let through wherever GET is.
+func (t *routeTable) handleFunc(pattern string, h http.HandlerFunc, methods ...string) {
+	t.routes = append(t.routes, route{pattern: pattern, methods: methods})
+	t.mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+		method := r.Method
+		if method == http.MethodHead {
+			method = http.MethodGet
+		}
+		if !slices.Contains(methods, method) {
+			w.Header().Set("Allow", strings.Join(methods, ", "))
+			writeError(w, &APIError{Status: http.StatusMethodNotAllowed, Code: CodeMethodNotAllowed, Message: "use " + strings.Join(methods, " or ")})
+			return
+		}
+		h(w, r)
+	})
+}
+
+func jsonOf(description string, v interface{}) response {
+	return response{Description: description, Content: map[string]interface{}{"application/json": v}}
+}
+
+func contentOf(description string, contentTypes ...string) response {
+	content := make(map[string]interface{}, len(contentTypes))
+	for _, ct := range contentTypes {
+		content[ct] = nil
+	}
+	return response{Description: description, Content: content}
+}
+
+var notModifiedResponse = response{Description: "The client's copy, named by If-None-Match, is still current"}
+
+func stringParam(name, in, description string, enum ...string) param {
+	schema := map[string]interface{}{"type": "string"}
+	if len(enum) > 0 {
+		schema["enum"] = enum
+	}
+	return param{Name: name, In: in, Description: description, Required: in == "path", Schema: schema}
+}
+
+func intQuery(name, description string, min, max int) param {
+	schema := map[string]interface{}{"type": "integer", "minimum": min}
+	if max > 0 {
+		schema["maximum"] = max
+	}
+	return param{Name: name, In: "query", Description: description, Schema: schema}
+}
+
+func required(p param) param {
+	p.Required = true
+	return p
+}
+
+var datasetParam = stringParam("dataset", "query", "Dataset to use; the default one when omitted")
+
+// searchQuery is the query string every search route reads. withMethod is
+// false for routes that fix the method, like /api/dfs.
+func searchQuery(withMethod bool) []param {
+	params := []param{datasetParam, required(stringParam("target", "query", "Element to search for; case, punctuation and aliases are forgiven"))}
+	if withMethod {
+		params = append(params, required(stringParam("method", "query", "Search method", methodNames()...)))
+	}
+	return append(params,
+		intQuery("count", "Recipes to find", 1, config.MaxCount),
+		intQuery("depth", "Maximum depth of the tree, unlimited when omitted", 1, MaxDepth),
+		timeoutParam(MaxTimeout),
+	)
+}
+
+func timeoutParam(max time.Duration) param {
+	return param{Name: "timeout", In: "query", Description: fmt.Sprintf("Time limit as a Go duration, %s to %s", MinTimeout, max),
+		Schema: map[string]interface{}{"type": "string", "example": "10s"}}
+}
+
+// jobQuery is the query string of a job, which may ask for a longer
+// timeout than other searches.
+func jobQuery() []param {
+	params := searchQuery(true)
+	params[len(params)-1] = timeoutParam(config.JobMaxTimeout)
+	return params
+}
+
+// searchResponse is the 200 response of a search: JSON, a graph export
+// picked by ?format= or, with ?stream=true, Server-Sent Events.
+func searchResponse() response {
+	res := jsonOf("The pruned search tree. format=cytoscape also answers application/json, with Cytoscape elements.", TreeResponse{})
+	for _, format := range recipe.ExportFormats {
+		ct := recipe.ContentType(format)
+		if _, ok := res.Content[ct]; !ok {
+			res.Content[ct] = nil
+		}
+	}
+	res.Content["text/event-stream"] = nil
+	return res
+}
+
+func streamParams() []param {
+	return []param{
+		{Name: "stream", In: "query", Description: "Stream progress as Server-Sent Events: progress, recipe-found, done and error events, each a TreeResponse or ErrorResponse",
+			Schema: map[string]interface{}{"type": "boolean"}},
+		stringParam("mode", "query", "What streamed events carry: the whole tree, or DeltaEvent lists", StreamModeTree, StreamModeDelta),
+		stringParam("format", "query", "Response format; not allowed with stream", append([]string{FormatJSON}, recipe.ExportFormats...)...),
+	}
+}
+
+func searchOperation(summary string, withMethod bool) operation {
+	return operation{
+		Summary:   summary,
+		Params:    append(searchQuery(withMethod), streamParams()...),
+		Responses: map[int]response{200: searchResponse(), 304: notModifiedResponse},
+	}
+}
+
+// apiOperations documents every route, keyed by ServeMux pattern and then by
+// method.
+func apiOperations() map[string]map[string]operation {
+	return map[string]map[string]operation{
+		"/healthz": {"get": {Summary: "Report that the process is alive", Responses: map[int]response{200: jsonOf("Alive", HealthResponse{})}}},
+		"/readyz": {"get": {
+			Summary:   "Report whether the datasets are loaded and the server is taking requests",
+			Responses: map[int]response{200: jsonOf("Ready, with the loaded datasets", ReadyResponse{}), 503: jsonOf("Loading or draining", ReadyResponse{})},
+		}},
+		"/metrics":          {"get": {Summary: "Prometheus metrics", Responses: map[int]response{200: contentOf("Metrics in the Prometheus text format", "text/plain")}}},
+		"/api/openapi.json": {"get": {Summary: "This document", Responses: map[int]response{200: contentOf("The OpenAPI document", "application/json")}}},
+
+		"/api/datasets": {"get": {Summary: "List the loaded datasets", Responses: map[int]response{200: jsonOf("The datasets", []DatasetDTO{})}}},
+		"/api/recipes": {"get": {
+			Summary: "Download a whole dataset, as JSON or as a recipe graph",
+			Params:  []param{datasetParam, stringParam("format", "query", "Response format", append([]string{FormatJSON}, recipe.ExportFormats...)...)},
+			Responses: map[int]response{
+				200: {Description: "The dataset", Content: map[string]interface{}{
+					"application/json":                   []recipe.Recipe{},
+					recipe.ContentType(recipe.FormatDOT): nil, recipe.ContentType(recipe.FormatMermaid): nil, recipe.ContentType(recipe.FormatGraphML): nil,
+				}},
+				304: notModifiedResponse,
+			},
+		}},
+		"/api/elements": {"get": {
+			Summary: "List and search the elements of a dataset",
+			Params: []param{
+				datasetParam,
+				stringParam("q", "query", "Text to look for in element names"),
+				stringParam("match", "query", "How q is matched", "prefix", "fuzzy"),
+				intQuery("tier", "Only elements of this tier", 0, 0),
+				intQuery("minTier", "Lowest tier to list", 0, 0),
+				intQuery("maxTier", "Highest tier to list", 0, 0),
+				stringParam("sort", "query", "Sort key; match order when omitted", "name", "tier"),
+				stringParam("order", "query", "Sort order", "asc", "desc"),
+				intQuery("limit", "Page size", 1, maxPageSize),
+				intQuery("offset", "Elements to skip", 0, 0),
+			},
+			Responses: map[int]response{200: jsonOf("A page of elements", ElementListResponse{})},
+		}},
+		"/api/elements/{name}": {"get": {
+			Summary:   "Get one element with its recipes and products",
+			Params:    []param{stringParam("name", "path", "Element name"), datasetParam},
+			Responses: map[int]response{200: jsonOf("The element", ElementDTO{})},
+		}},
+		"/api/images/{element}": {"get": {
+			Summary:   "Get the icon of an element, or a generated placeholder",
+			Params:    []param{stringParam("element", "path", "Element name"), datasetParam},
+			Responses: map[int]response{200: contentOf("The icon", "image/png", "image/svg+xml"), 304: notModifiedResponse},
+		}},
+
+		"/api/search": {"get": searchOperation("Search for recipes of an element", true)},
+		"/api/dfs":    {"get": searchOperation("Search for recipes of an element depth first", false)},
+		"/api/bfs":    {"get": searchOperation("Search for recipes of an element breadth first", false)},
+		"/api/bidirectional": {"get": {
+			Summary:   "Bidirectional search, not implemented",
+			Responses: map[int]response{501: jsonOf("Not implemented", ErrorResponse{})},
+		}},
+		"/api/search/batch": {"post": {
+			Summary:   "Run several searches in one request",
+			Params:    []param{stringParam("format", "query", "ndjson writes one BatchItemResult per line as each finishes", "ndjson")},
+			Body:      BatchRequest{},
+			Responses: map[int]response{200: {Description: "Every result, in request order, or one per line", Content: map[string]interface{}{"application/json": BatchResponse{}, "application/x-ndjson": nil}}},
+		}},
+		"/api/compare": {"get": {
+			Summary:   "Run every search method on the same search and compare them",
+			Params:    searchQuery(false),
+			Responses: map[int]response{200: jsonOf("One result per method", CompareResponse{})},
+		}},
+		"/api/render": {"get": {
+			Summary:   "Draw the tree of a search",
+			Params:    append(searchQuery(true), stringParam("format", "query", "Image format", RenderSVG, RenderPNG)),
+			Responses: map[int]response{200: contentOf("The image", "image/svg+xml", "image/png"), 304: notModifiedResponse, 422: jsonOf("The tree is too large to draw", ErrorResponse{})},
+		}},
+		"/api/graphql": {
+			"get": {
+				Summary: "Run a GraphQL query",
+				Params: []param{
+					required(stringParam("query", "query", "The query; the schema can be read by introspection")),
+					stringParam("operationName", "query", "Operation to run when the query holds several"),
+					stringParam("variables", "query", "Variables as a JSON object"),
+				},
+				Responses: map[int]response{200: jsonOf("The data, and the error of every field that failed", GraphQLResponse{})},
+			},
+			"post": {
+				Summary: "Run a GraphQL query",
+				Description: fmt.Sprintf("Elements, their recipes and products, and searches, in one round trip. A query may run at most %d searches, nest at most %d levels and return at most %d elements, recipes and tree nodes; products come in pages of at most %d. Search trees deeper than the query can nest are read through SearchResult.nodes.",
+					MaxGraphQLSearches, MaxGraphQLDepth, MaxGraphQLObjects, MaxGraphQLProducts),
+				Body:      GraphQLRequest{},
+				Responses: map[int]response{200: jsonOf("The data, and the error of every field that failed", GraphQLResponse{})},
+			},
+		},
+		"/api/ws": {"get": {
+			Summary:     "Run interactive searches over a WebSocket",
+			Description: "The client sends WSCommand messages (start, pause, resume, speed, cancel) and receives WSEvent messages.",
+			Responses:   map[int]response{101: {Description: "Switching to the WebSocket protocol"}},
+		}},
+
+		"/api/jobs": {
+			"get": {Summary: "List the jobs", Responses: map[int]response{200: jsonOf("The jobs", JobListResponse{})}},
+			"post": {
+				Summary:   "Queue a search to run in the background",
+				Params:    jobQuery(),
+				Body:      SearchRequest{},
+				Responses: map[int]response{202: jsonOf("The queued job; its URL is in Location", JobDTO{})},
+			},
+		},
+		"/api/jobs/{id}": {
+			"get":    {Summary: "Get a job, with its result once it's done", Params: []param{stringParam("id", "path", "Job id")}, Responses: map[int]response{200: jsonOf("The job", JobDTO{})}},
+			"delete": {Summary: "Cancel a job", Params: []param{stringParam("id", "path", "Job id")}, Responses: map[int]response{200: jsonOf("The cancelled job", JobDTO{})}},
+		},
+
+		"/api/admin/reload": {"post": {Summary: "Reload the datasets and aliases from disk and flush the result cache", Responses: map[int]response{200: jsonOf("The reloaded datasets", ReloadResponse{})}}},
+		"/api/admin/scrape": {
+			"get": {Summary: "Get the state of the last scrape", Responses: map[int]response{200: jsonOf("The last scrape", ScrapeStatus{})}},
+			"post": {
+				Summary:   "Scrape a source again, then reload the datasets",
+				Params:    []param{stringParam("source", "query", "la2, la1 or the name of a source in scrape-sources")},
+				Responses: map[int]response{202: jsonOf("The scrape has started", ScrapeStatus{})},
+			},
+		},
+		"/api/admin/cache": {"delete": {Summary: "Flush the result cache", Responses: map[int]response{200: jsonOf("How many results were dropped", FlushResponse{})}}},
+		"/api/admin/jobs": {"get": {
+			Summary:   "List every job and count the running searches",
+			Params:    []param{stringParam("status", "query", "Only jobs in this state", JobQueued, JobRunning, JobDone, JobCancelled)},
+			Responses: map[int]response{200: jsonOf("The jobs", AdminJobsResponse{})},
+		}},
+	}
+}
+
+// extraSchemas are documented although no route returns them as a whole:
+// they travel over WebSocket and SSE.
+var extraSchemas = []interface{}{WSCommand{}, WSEvent{}, DeltaEvent{}, ParameterDetails{}, UnknownElementDetails{}}
+
+// schemaBuilder turns Go types into JSON schemas, collecting every named
+// struct in schemas and referring to it by name. err is the first type it
+// could not describe.
+type schemaBuilder struct {
+	schemas map[string]interface{}
+	err     error
+}
+
+var (
+	timeType    = reflect.TypeOf(time.Time{})
+	rawJSONType = reflect.TypeOf(json.RawMessage{})
+)
+
+func (b *schemaBuilder) schema(t reflect.Type) map[string]interface{} {
+	switch t {
+	case timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case rawJSONType:
+		return map[string]interface{}{"description": "Any JSON value"}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return b.schema(t.Elem())
+	case reflect.Interface:
+		return map[string]interface{}{}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Int64:
+		return map[string]interface{}{"type": "integer", "format": "int64"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "minimum": 0}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": b.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": b.schema(t.Elem())}
+	case reflect.Struct:
+		name := t.Name()
+		if _, ok := b.schemas[name]; !ok {
+			b.schemas[name] = nil // so recursive types stop here
+			b.schemas[name] = b.object(t)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+	}
+	if b.err == nil {
+		b.err = fmt.Errorf("openapi: no schema for %s", t)
+	}
+	return map[string]interface{}{}
+}
+
+// object follows encoding/json: fields named by their json tag, skipped for
+// "-", optional when omitempty, and embedded structs flattened.
+func (b *schemaBuilder) object(t reflect.Type) map[string]interface{} {
+	properties := make(map[string]interface{})
+	var requiredFields []string
+	var add func(t reflect.Type)
+	add = func(t reflect.Type) {
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			tag := f.Tag.Get("json")
+			if tag == "-" {
+				continue
+			}
+			name, opts, _ := strings.Cut(tag, ",")
+			if f.Anonymous && name == "" {
+				add(f.Type)
+				continue
+			}
+			if !f.IsExported() {
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			properties[name] = b.schema(f.Type)
+			if !slices.Contains(strings.Split(opts, ","), "omitempty") {
+				requiredFields = append(requiredFields, name)
+			}
+		}
+	}
+	add(t)
+
+	obj := map[string]interface{}{"type": "object", "properties": properties}
+	if len(requiredFields) > 0 {
+		sort.Strings(requiredFields)
+		obj["required"] = requiredFields
+	}
+	return obj
+}
+
+func openAPISpec() (map[string]interface{}, error) {
+	b := &schemaBuilder{schemas: make(map[string]interface{})}
+	errorSchema := b.schema(reflect.TypeOf(ErrorResponse{}))
+	for _, v := range extraSchemas {
+		b.schema(reflect.TypeOf(v))
+	}
+
+	content := func(c map[string]interface{}) map[string]interface{} {
+		out := make(map[string]interface{}, len(c))
+		for ct, v := range c {
+			if v == nil {
+				out[ct] = map[string]interface{}{"schema": map[string]interface{}{"type": "string"}}
+			} else {
+				out[ct] = map[string]interface{}{"schema": b.schema(reflect.TypeOf(v))}
+			}
+		}
+		return out
+	}
+
+	paths := make(map[string]interface{})
+	for path, methods := range apiOperations() {
+		item := make(map[string]interface{})
+		for method, op := range methods {
+			o := map[string]interface{}{"summary": op.Summary}
+			if op.Description != "" {
+				o["description"] = op.Description
+			}
+			if len(op.Params) > 0 {
+				var params []interface{}
+				for _, p := range op.Params {
+					params = append(params, map[string]interface{}{
+						"name": p.Name, "in": p.In, "description": p.Description, "required": p.Required, "schema": p.Schema,
+					})
+				}
+				o["parameters"] = params
+			}
+			if op.Body != nil {
+				o["requestBody"] = map[string]interface{}{"content": content(map[string]interface{}{"application/json": op.Body})}
+			}
+
+			responses := map[string]interface{}{
+				"default": map[string]interface{}{"description": "An error", "content": map[string]interface{}{"application/json": map[string]interface{}{"schema": errorSchema}}},
+			}
+			for status, res := range op.Responses {
+				r := map[string]interface{}{"description": res.Description}
+				if len(res.Content) > 0 {
+					r["content"] = content(res.Content)
+				}
+				responses[fmt.Sprint(status)] = r
+			}
+			o["responses"] = responses
+
+			if strings.HasPrefix(path, "/api/admin/") {
+				o["security"] = []interface{}{map[string]interface{}{"apiKey": []string{}}, map[string]interface{}{"bearer": []string{}}}
+			}
+			item[method] = o
+		}
+		paths[path] = item
+	}
+
+	if b.err != nil {
+		return nil, b.err
+	}
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":       "Crafting Table API",
+			"description": "Recipe search over Little Alchemy datasets. Errors are always an ErrorResponse; 429 responses carry Retry-After.",
+			"version":     "1.0.0",
+		},
+		"paths": paths,
+		// Anonymous access works unless the server runs with require-key.
+		"security": []interface{}{map[string]interface{}{}, map[string]interface{}{"apiKey": []string{}}, map[string]interface{}{"bearer": []string{}}},
+		"components": map[string]interface{}{
+			"schemas": b.schemas,
+			"securitySchemes": map[string]interface{}{
+				"apiKey": map[string]interface{}{"type": "apiKey", "in": "header", "name": "X-API-Key"},
+				"bearer": map[string]interface{}{"type": "http", "scheme": "bearer"},
+			},
+		},
+	}, nil
+}
+
+// checkSpec reports the routes and methods that are registered but not
+// documented, and the other way round.
+func checkSpec(routes []route) error {
+	if _, err := openAPISpec(); err != nil {
+		return err
+	}
+	ops := apiOperations()
+	var problems []string
+	registered := make(map[string]bool)
+	for _, rt := range routes {
+		registered[rt.pattern] = true
+		methods, ok := ops[rt.pattern]
+		if !ok {
+			problems = append(problems, rt.pattern+" is not documented")
+			continue
+		}
+		for _, method := range rt.methods {
+			if _, ok := methods[strings.ToLower(method)]; !ok {
+				problems = append(problems, method+" "+rt.pattern+" is not documented")
+			}
+		}
+		for method := range methods {
+			if !slices.Contains(rt.methods, strings.ToUpper(method)) {
+				problems = append(problems, strings.ToUpper(method)+" "+rt.pattern+" is documented but not registered")
+			}
+		}
+	}
+	for path := range ops {
+		if !registered[path] {
+			problems = append(problems, path+" is documented but not registered")
+		}
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("the OpenAPI document is out of date: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+func openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	spec, err := openAPISpec()
+	if err != nil {
+		writeError(w, &APIError{Status: http.StatusInternalServerError, Code: CodeInternal, Message: err.Error()})
+		return
+	}
+	writeJSON(w, spec)
+}
@@ -0,0 +1,47 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var pathParam = regexp.MustCompile(`\{[^}]+\}`)
+
+func TestSpecMatchesRoutes(t *testing.T) {
+	mux := http.NewServeMux()
+	routes := registerRoutes(mux)
+	if err := checkSpec(routes); err != nil {
+		t.Fatal(err)
+	}
+
+	for path, ops := range apiOperations() {
+		url := pathParam.ReplaceAllString(path, "x")
+		for _, method := range []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete} {
+			req := httptest.NewRequest(method, url, nil)
+			if _, pattern := mux.Handler(req); pattern != path {
+				t.Errorf("%s %s is served by %q, want %q", method, url, pattern, path)
+				continue
+			}
+			if _, documented := ops[strings.ToLower(method)]; documented {
+				continue
+			}
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+			if rec.Code != http.StatusMethodNotAllowed {
+				t.Errorf("%s %s: got %d, want 405 for an undocumented method", method, url, rec.Code)
+			}
+		}
+	}
+}
+
+func TestSpecReportsUndescribableTypes(t *testing.T) {
+	b := &schemaBuilder{schemas: make(map[string]interface{})}
+	b.schema(reflect.TypeOf(struct{ C chan int }{}))
+	if b.err == nil {
+		t.Fatal("no error for a chan field")
+	}
+}
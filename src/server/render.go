@@ -0,0 +1,323 @@
+package server
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"html"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"math"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/fogleman/gg"
+
+	recipe "github.com/Henshou/Tubes2_BE_CraftingTable.git/recipe"
+)
+
+const (
+	RenderSVG = "svg"
+	RenderPNG = "png"
+
+	// MaxRenderNodes and MaxPNGPixels keep rendered images to a size a
+	// browser can open.
+	MaxRenderNodes = 2000
+	MaxPNGPixels   = 25000000
+
+	nodeWidth  = 96.0
+	nodeHeight = 64.0
+	iconSize   = 32.0
+	hGap       = 16.0
+	rowHeight  = nodeHeight + 48.0
+	margin     = 24.0
+)
+
+// layoutNode is an element placed on the image. X is the centre of its box
+// and Y the top.
+type layoutNode struct {
+	Name    string
+	X, Y    float64
+	Recipes []layoutRecipe
+}
+
+// layoutRecipe is the junction between an element and the inputs of one of
+// its recipes.
+type layoutRecipe struct {
+	X, Y   float64
+	Inputs []*layoutNode
+}
+
+type treeLayout struct {
+	Root          *layoutNode
+	Nodes         int
+	Width, Height float64
+}
+
+// layoutTree places every element of the tree in rows by depth. Leaves are
+// laid out left to right and every element is centred over its inputs.
+func layoutTree(tree NodeDTO) treeLayout {
+	var l treeLayout
+	var place func(n NodeDTO, left float64, depth int) (*layoutNode, float64)
+	place = func(n NodeDTO, left float64, depth int) (*layoutNode, float64) {
+		l.Nodes++
+		ln := &layoutNode{Name: n.Name, Y: margin + float64(depth)*rowHeight}
+		l.Height = math.Max(l.Height, ln.Y+nodeHeight+margin)
+
+		x := left
+		for i, r := range n.Recipes {
+			if i > 0 {
+				x += hGap
+			}
+			lr := layoutRecipe{Y: ln.Y + nodeHeight + (rowHeight-nodeHeight)/2}
+			start := x
+			for _, in := range r.Inputs {
+				child, width := place(in, x, depth+1)
+				lr.Inputs = append(lr.Inputs, child)
+				x += width
+			}
+			lr.X = (start + x) / 2
+			ln.Recipes = append(ln.Recipes, lr)
+		}
+
+		width := math.Max(x-left, nodeWidth+hGap)
+		ln.X = left + width/2
+		l.Width = math.Max(l.Width, left+width+margin)
+		return ln, width
+	}
+	l.Root, _ = place(tree, margin, 0)
+	return l
+}
+
+func (l treeLayout) walk(fn func(n *layoutNode)) {
+	var walk func(n *layoutNode)
+	walk = func(n *layoutNode) {
+		fn(n)
+		for _, r := range n.Recipes {
+			for _, in := range r.Inputs {
+				walk(in)
+			}
+		}
+	}
+	walk(l.Root)
+}
+
+// renderHandler draws the tree of a search as SVG, or as PNG with
+// ?format=png. It takes the same parameters as the other searches.
+func renderHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	format := strings.ToLower(query.Get("format"))
+	if format == "" {
+		format = RenderSVG
+	}
+	if format != RenderSVG && format != RenderPNG {
+		writeError(w, invalidParameter(ParameterDetails{Parameter: "format", Value: format, Allowed: []string{RenderSVG, RenderPNG}},
+			"format must be %s or %s", RenderSVG, RenderPNG))
+		return
+	}
+	query.Del("format")
+	query.Del("stream")
+
+	p, apiErr := parseSearchParams(query, "")
+	if apiErr != nil {
+		writeError(w, apiErr)
+		return
+	}
+	p.Logger, p.Client = requestLogger(r), clientID(r)
+	p.Logger.Debug("render requested", "method", p.Method, "target", p.Target, "count", p.Count, "format", format)
+
+	res, _, apiErr := cachedSearch(p)
+	if apiErr != nil {
+		writeError(w, apiErr)
+		return
+	}
+	var result TreeResponse
+	if err := json.Unmarshal(res.body, &result); err != nil {
+		writeError(w, &APIError{Status: http.StatusInternalServerError, Code: CodeInternal, Message: "cannot decode search result"})
+		return
+	}
+
+	layout := layoutTree(result.Tree)
+	if layout.Nodes > MaxRenderNodes {
+		writeError(w, &APIError{
+			Status:  http.StatusUnprocessableEntity,
+			Code:    CodeTreeTooLarge,
+			Message: fmt.Sprintf("the tree has %d elements, at most %d can be rendered; lower count or depth", layout.Nodes, MaxRenderNodes),
+		})
+		return
+	}
+
+	if format == RenderPNG && layout.Width*layout.Height > MaxPNGPixels {
+		writeError(w, &APIError{
+			Status:  http.StatusUnprocessableEntity,
+			Code:    CodeTreeTooLarge,
+			Message: fmt.Sprintf("the tree is %.0fx%.0f pixels, too large for PNG; lower count or depth, or ask for SVG", layout.Width, layout.Height),
+		})
+		return
+	}
+
+	var buf bytes.Buffer
+	var err error
+	if format == RenderPNG {
+		err = renderPNG(&buf, layout, p.Recipes)
+	} else {
+		renderSVG(&buf, layout, p.Recipes)
+	}
+	if err != nil {
+		writeError(w, &APIError{Status: http.StatusInternalServerError, Code: CodeInternal, Message: "cannot render tree: " + err.Error()})
+		return
+	}
+
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", SearchCacheMaxAge))
+	if notModified(w, r, `"`+contentHash(buf.Bytes())+`"`) {
+		return
+	}
+	w.Header().Set("Content-Type", mime.TypeByExtension("."+format))
+	w.Write(buf.Bytes())
+}
+
+// localIcon returns the mirrored icon of an element, if there is one.
+func localIcon(el recipe.Recipe) ([]byte, string, bool) {
+	if el.ImageFile == "" {
+		return nil, "", false
+	}
+	file := filepath.Base(el.ImageFile)
+	data, err := os.ReadFile(imagePath(file))
+	if err != nil {
+		return nil, "", false
+	}
+	return data, mime.TypeByExtension(filepath.Ext(file)), true
+}
+
+// renderSVG writes a self-contained SVG: icons are inlined as data URIs, so
+// the image can be embedded anywhere without reaching this server.
+func renderSVG(buf *bytes.Buffer, l treeLayout, recipes map[string]recipe.Recipe) {
+	fmt.Fprintf(buf, `<svg xmlns="http://www.w3.org/2000/svg" width="%.0f" height="%.0f" viewBox="0 0 %.0f %.0f" font-family="sans-serif" font-size="11">`+"\n",
+		l.Width, l.Height, l.Width, l.Height)
+	fmt.Fprintf(buf, `<rect width="100%%" height="100%%" fill="#fff"/>`+"\n")
+
+	icons := make(map[string]string)
+	l.walk(func(n *layoutNode) {
+		for _, r := range n.Recipes {
+			fmt.Fprintf(buf, `<line x1="%.1f" y1="%.1f" x2="%.1f" y2="%.1f" stroke="#888"/>`+"\n", n.X, n.Y+nodeHeight, r.X, r.Y)
+			for _, in := range r.Inputs {
+				fmt.Fprintf(buf, `<line x1="%.1f" y1="%.1f" x2="%.1f" y2="%.1f" stroke="#888"/>`+"\n", r.X, r.Y, in.X, in.Y)
+			}
+			fmt.Fprintf(buf, `<circle cx="%.1f" cy="%.1f" r="7" fill="#fff" stroke="#888"/>`, r.X, r.Y)
+			fmt.Fprintf(buf, `<text x="%.1f" y="%.1f" dy=".35em" text-anchor="middle" fill="#555">+</text>`+"\n", r.X, r.Y)
+		}
+	})
+	l.walk(func(n *layoutNode) {
+		href, ok := icons[n.Name]
+		if !ok {
+			if data, mimeType, found := localIcon(recipes[n.Name]); found {
+				href = "data:" + mimeType + ";base64," + base64.StdEncoding.EncodeToString(data)
+			} else {
+				href = "data:image/svg+xml;base64," + base64.StdEncoding.EncodeToString(placeholderSVG(n.Name))
+			}
+			icons[n.Name] = href
+		}
+
+		left := n.X - nodeWidth/2
+		fmt.Fprintf(buf, `<rect x="%.1f" y="%.1f" width="%.0f" height="%.0f" rx="8" fill="#f7f7f7" stroke="#bbb"/>`, left, n.Y, nodeWidth, nodeHeight)
+		fmt.Fprintf(buf, `<image x="%.1f" y="%.1f" width="%.0f" height="%.0f" href="%s"/>`, n.X-iconSize/2, n.Y+6, iconSize, iconSize, href)
+		fmt.Fprintf(buf, `<text x="%.1f" y="%.1f" text-anchor="middle">%s</text>`+"\n", n.X, n.Y+nodeHeight-10, html.EscapeString(n.Name))
+	})
+	buf.WriteString("</svg>\n")
+}
+
+func renderPNG(buf *bytes.Buffer, l treeLayout, recipes map[string]recipe.Recipe) error {
+	dc := gg.NewContext(int(math.Ceil(l.Width)), int(math.Ceil(l.Height)))
+	dc.SetRGB(1, 1, 1)
+	dc.Clear()
+
+	dc.SetLineWidth(1)
+	l.walk(func(n *layoutNode) {
+		for _, r := range n.Recipes {
+			dc.SetHexColor("#888")
+			dc.DrawLine(n.X, n.Y+nodeHeight, r.X, r.Y)
+			for _, in := range r.Inputs {
+				dc.DrawLine(r.X, r.Y, in.X, in.Y)
+			}
+			dc.Stroke()
+			dc.DrawCircle(r.X, r.Y, 7)
+			dc.SetRGB(1, 1, 1)
+			dc.FillPreserve()
+			dc.SetHexColor("#888")
+			dc.Stroke()
+			dc.SetHexColor("#555")
+			dc.DrawStringAnchored("+", r.X, r.Y, 0.5, 0.35)
+		}
+	})
+
+	icons := make(map[string]image.Image)
+	l.walk(func(n *layoutNode) {
+		dc.DrawRoundedRectangle(n.X-nodeWidth/2, n.Y, nodeWidth, nodeHeight, 8)
+		dc.SetHexColor("#f7f7f7")
+		dc.FillPreserve()
+		dc.SetHexColor("#bbb")
+		dc.Stroke()
+
+		icon, ok := icons[n.Name]
+		if !ok {
+			if data, _, found := localIcon(recipes[n.Name]); found {
+				icon, _, _ = image.Decode(bytes.NewReader(data))
+			}
+			icons[n.Name] = icon
+		}
+		if icon != nil {
+			size := icon.Bounds().Size()
+			scale := iconSize / math.Max(float64(size.X), float64(size.Y))
+			dc.Push()
+			dc.Translate(n.X-iconSize/2, n.Y+6)
+			dc.Scale(scale, scale)
+			dc.DrawImage(icon, 0, 0)
+			dc.Pop()
+		} else {
+			drawPlaceholder(dc, n.Name, n.X, n.Y+6+iconSize/2)
+		}
+
+		dc.SetHexColor("#222")
+		dc.DrawStringAnchored(n.Name, n.X, n.Y+nodeHeight-10, 0.5, 0)
+	})
+
+	return png.Encode(buf, dc.Image())
+}
+
+// drawPlaceholder is the PNG version of placeholderSVG.
+func drawPlaceholder(dc *gg.Context, name string, x, y float64) {
+	r, g, b := hslToRGB(float64(placeholderHue(name)), 0.55, 0.55)
+	dc.DrawCircle(x, y, iconSize/2)
+	dc.SetRGB(r, g, b)
+	dc.Fill()
+	dc.SetRGB(1, 1, 1)
+	dc.DrawStringAnchored(initials(name), x, y, 0.5, 0.35)
+}
+
+func hslToRGB(h, s, l float64) (float64, float64, float64) {
+	c := (1 - math.Abs(2*l-1)) * s
+	x := c * (1 - math.Abs(math.Mod(h/60, 2)-1))
+	m := l - c/2
+	var r, g, b float64
+	switch {
+	case h < 60:
+		r, g, b = c, x, 0
+	case h < 120:
+		r, g, b = x, c, 0
+	case h < 180:
+		r, g, b = 0, c, x
+	case h < 240:
+		r, g, b = 0, x, c
+	case h < 300:
+		r, g, b = x, 0, c
+	default:
+		r, g, b = c, 0, x
+	}
+	return r + m, g + m, b + m
+}
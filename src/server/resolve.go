@@ -0,0 +1,146 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+const maxSuggestions = 5
+
+// aliases maps alternative spellings to element names. It is loaded from
+// aliases.json, a flat {"alias": "Element"} object, when that file exists.
+var aliases = make(map[string]string)
+
+func loadAliases(file string) error {
+	data, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var raw map[string]string
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	loaded := make(map[string]string, len(raw))
+	for alias, name := range raw {
+		loaded[normalizeName(alias)] = name
+	}
+	datasetsMu.Lock()
+	aliases = loaded
+	datasetsMu.Unlock()
+	slog.Info("loaded aliases", "aliases", len(loaded))
+	return nil
+}
+
+// normalizeName folds case, drops punctuation and collapses whitespace, so
+// "  little-alchemy (Element)" and "Little alchemy element" compare equal.
+func normalizeName(name string) string {
+	var b strings.Builder
+	space := false
+	for _, r := range strings.ToLower(name) {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			if space && b.Len() > 0 {
+				b.WriteByte(' ')
+			}
+			space = false
+			b.WriteRune(r)
+		case unicode.IsSpace(r) || r == '-' || r == '_':
+			space = true
+		}
+	}
+	return b.String()
+}
+
+// resolve maps a user-supplied name to the element it refers to: an exact
+// match first, then a normalized one, then an alias.
+func (c *catalog) resolve(name string) (string, bool) {
+	if _, ok := c.byName[name]; ok {
+		return name, true
+	}
+	key := normalizeName(name)
+	if canonical, ok := c.normalized[key]; ok {
+		return canonical, true
+	}
+	if canonical, ok := aliases[key]; ok {
+		if _, ok := c.byName[canonical]; ok {
+			return canonical, true
+		}
+	}
+	return "", false
+}
+
+// suggest returns the names closest to name by edit distance.
+func (c *catalog) suggest(name string) []string {
+	key := normalizeName(name)
+	limit := max(2, len(key)/3)
+
+	type candidate struct {
+		name     string
+		distance int
+	}
+	var candidates []candidate
+	for normalized, canonical := range c.normalized {
+		if d := levenshtein(key, normalized); d <= limit {
+			candidates = append(candidates, candidate{canonical, d})
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].distance != candidates[j].distance {
+			return candidates[i].distance < candidates[j].distance
+		}
+		return candidates[i].name < candidates[j].name
+	})
+
+	suggestions := make([]string, 0, maxSuggestions)
+	for i := 0; i < len(candidates) && i < maxSuggestions; i++ {
+		suggestions = append(suggestions, candidates[i].name)
+	}
+	return suggestions
+}
+
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(rb)]
+}
+
+// lookupElement resolves name in a dataset's catalog, returning a not found
+// error with suggestions when it doesn't match any element.
+func lookupElement(dataset, name string) (string, *APIError) {
+	datasetsMu.RLock()
+	defer datasetsMu.RUnlock()
+	c := catalogs[dataset]
+	if canonical, ok := c.resolve(name); ok {
+		return canonical, nil
+	}
+	return "", &APIError{
+		Status:  http.StatusNotFound,
+		Code:    CodeUnknownElement,
+		Message: fmt.Sprintf("unknown element %q", name),
+		Details: UnknownElementDetails{Name: name, Suggestions: c.suggest(name)},
+	}
+}
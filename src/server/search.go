@@ -0,0 +1,530 @@
+package server
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	recipe "github.com/Henshou/Tubes2_BE_CraftingTable.git/recipe"
+)
+
+const (
+	StreamDelay    = 500 * time.Millisecond
+	MaxStreamDelay = 5 * time.Second
+	MaxDepth       = 64
+	FormatJSON     = "json"
+	MinTimeout     = 100 * time.Millisecond
+	MaxTimeout     = 60 * time.Second
+	DefaultTimeout = MaxTimeout
+	// MaxPause is how long a search may stay paused in total. Its timeout
+	// runs again after that, so a paused search can't hold its slot forever.
+	MaxPause = 2 * time.Minute
+)
+
+type builderFunc func(
+	root *recipe.RecipeTreeNode,
+	recipeMap map[string]recipe.Recipe,
+	maxRecipes int,
+	maxDepth int,
+	stopChan chan bool,
+	wg *sync.WaitGroup,
+	mu *sync.Mutex,
+	nodesVisited *int,
+	treeChan chan *recipe.RecipeTreeNode,
+	pace *recipe.Pace,
+	logger *slog.Logger,
+)
+
+type searchMethod struct {
+	Name  string
+	Build builderFunc
+}
+
+// methods are the search algorithms clients can pick with ?method=.
+var methods = map[string]searchMethod{
+	"dfs": {Name: "DFS", Build: recipe.BuildRecipeTreeDFS},
+	"bfs": {Name: "BFS", Build: recipe.BuildRecipeTreeBFS},
+}
+
+func methodNames() []string {
+	names := make([]string, 0, len(methods))
+	for name := range methods {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+type searchParams struct {
+	Dataset string
+	Recipes map[string]recipe.Recipe
+	Target  string
+	Method  string
+	Count   int
+	Depth   int
+	Timeout time.Duration
+	Stream  bool
+	Mode    string
+	Format  string
+	// Logger carries the id of the request the search runs for.
+	Logger *slog.Logger
+	// Client is the clientID the search counts against, empty for searches
+	// nobody waits on. Claimed is set when the caller has already counted
+	// it, as jobs are from the moment they are queued; the search still
+	// releases it when it finishes.
+	Client  string
+	Claimed bool
+}
+
+func (p searchParams) logger() *slog.Logger {
+	if p.Logger == nil {
+		return slog.Default()
+	}
+	return p.Logger
+}
+
+// parseSearchParams validates the query of a search request. method is
+// used instead of the method parameter when it isn't empty.
+func parseSearchParams(query url.Values, method string) (searchParams, *APIError) {
+	var p searchParams
+	var apiErr *APIError
+
+	p.Dataset, p.Recipes, apiErr = datasetByName(query.Get("dataset"))
+	if apiErr != nil {
+		return p, apiErr
+	}
+
+	target := strings.TrimSpace(query.Get("target"))
+	if target == "" {
+		return p, missingParameter("target")
+	}
+	if p.Target, apiErr = lookupElement(p.Dataset, target); apiErr != nil {
+		return p, apiErr
+	}
+
+	p.Method = method
+	if p.Method == "" {
+		p.Method = strings.ToLower(query.Get("method"))
+	}
+	if p.Method == "" {
+		return p, missingParameter("method")
+	}
+	if _, ok := methods[p.Method]; !ok {
+		return p, invalidParameter(ParameterDetails{Parameter: "method", Value: p.Method, Allowed: methodNames()},
+			"method must be one of %s", strings.Join(methodNames(), ", "))
+	}
+
+	if p.Count, apiErr = intParam(query, "count", 1, 1, config.MaxCount); apiErr != nil {
+		return p, apiErr
+	}
+	if p.Depth, apiErr = intParam(query, "depth", 0, 1, MaxDepth); apiErr != nil {
+		return p, apiErr
+	}
+	if p.Timeout, apiErr = durationParam(query, "timeout", DefaultTimeout, MinTimeout, MaxTimeout); apiErr != nil {
+		return p, apiErr
+	}
+	if p.Stream, apiErr = boolParam(query, "stream"); apiErr != nil {
+		return p, apiErr
+	}
+	switch p.Mode = query.Get("mode"); p.Mode {
+	case "":
+		p.Mode = StreamModeTree
+	case StreamModeTree, StreamModeDelta:
+	default:
+		return p, invalidParameter(ParameterDetails{Parameter: "mode", Value: p.Mode, Allowed: []string{StreamModeTree, StreamModeDelta}},
+			"mode must be %s or %s", StreamModeTree, StreamModeDelta)
+	}
+	if p.Format, apiErr = formatParam(query); apiErr != nil {
+		return p, apiErr
+	}
+	if p.Stream && p.Format != FormatJSON {
+		return p, invalidParameter(ParameterDetails{Parameter: "format", Value: p.Format, Allowed: []string{FormatJSON}},
+			"streamed searches can only be sent as %s", FormatJSON)
+	}
+	return p, nil
+}
+
+// formatParam reads the format parameter: json, or one of the graph export
+// formats.
+func formatParam(query url.Values) (string, *APIError) {
+	format := strings.ToLower(query.Get("format"))
+	if format == "" || format == FormatJSON {
+		return FormatJSON, nil
+	}
+	if slices.Contains(recipe.ExportFormats, format) {
+		return format, nil
+	}
+	allowed := append([]string{FormatJSON}, recipe.ExportFormats...)
+	return "", invalidParameter(ParameterDetails{Parameter: "format", Value: format, Allowed: allowed},
+		"format must be one of %s", strings.Join(allowed, ", "))
+}
+
+func intParam(query url.Values, name string, def, min, max int) (int, *APIError) {
+	s := query.Get(name)
+	if s == "" {
+		return def, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < min || v > max {
+		return 0, invalidParameter(ParameterDetails{Parameter: name, Value: s, Min: min, Max: max},
+			"%s must be an integer between %d and %d", name, min, max)
+	}
+	return v, nil
+}
+
+// durationParam accepts Go durations ("5s") or plain milliseconds.
+func durationParam(query url.Values, name string, def, min, max time.Duration) (time.Duration, *APIError) {
+	s := query.Get(name)
+	if s == "" {
+		return def, nil
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		ms, msErr := strconv.Atoi(s)
+		v, err = time.Duration(ms)*time.Millisecond, msErr
+	}
+	if err != nil || v < min || v > max {
+		return 0, invalidParameter(ParameterDetails{Parameter: name, Value: s, Min: min.String(), Max: max.String()},
+			"%s must be a duration between %s and %s", name, min, max)
+	}
+	return v, nil
+}
+
+func boolParam(query url.Values, name string) (bool, *APIError) {
+	switch s := query.Get(name); s {
+	case "", "0", "false":
+		return false, nil
+	case "1", "true":
+		return true, nil
+	default:
+		return false, invalidParameter(ParameterDetails{Parameter: name, Value: s, Allowed: []string{"0", "1", "true", "false"}},
+			"%s must be 0 or 1", name)
+	}
+}
+
+func searchHandler(w http.ResponseWriter, r *http.Request) {
+	handleSearch(w, r, "")
+}
+
+func dfsHandler(w http.ResponseWriter, r *http.Request) {
+	handleSearch(w, r, "dfs")
+}
+
+func bfsHandler(w http.ResponseWriter, r *http.Request) {
+	handleSearch(w, r, "bfs")
+}
+
+// searchSlots has room for config.MaxConcurrentSearches running searches. It
+// is nil when there is no limit.
+var searchSlots chan struct{}
+
+// search is one run of a search method, started by startSearch. treeChan
+// receives the root whenever the tree changed and is closed once the builder
+// returns; updates the reader hasn't picked up yet are merged into one.
+type search struct {
+	params searchParams
+	method searchMethod
+	log    *slog.Logger
+
+	root     *recipe.RecipeTreeNode
+	stopChan chan bool
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+	mu       sync.Mutex
+	treeChan chan *recipe.RecipeTreeNode
+	pace     *recipe.Pace
+
+	start        time.Time
+	nodesVisited int
+
+	timerMu    sync.Mutex
+	timer      *time.Timer
+	remaining  time.Duration
+	deadline   time.Time
+	pauseTimer *time.Timer
+	pausedAt   time.Time
+	pausedFor  time.Duration
+	timedOut   atomic.Bool
+	cancelled  atomic.Bool
+}
+
+// startSearch runs the search described by p in the background. pace is nil
+// for searches that don't stream. It fails when p.Client already runs
+// config.ClientConcurrentSearches searches.
+func startSearch(p searchParams, pace *recipe.Pace) (*search, *APIError) {
+	if !p.Claimed {
+		if apiErr := claimSearch(p.Client); apiErr != nil {
+			return nil, apiErr
+		}
+	}
+	s := &search{
+		params:   p,
+		method:   methods[p.Method],
+		root:     &recipe.RecipeTreeNode{Name: p.Target},
+		stopChan: make(chan bool),
+		treeChan: make(chan *recipe.RecipeTreeNode, 1),
+		pace:     pace,
+		start:    time.Now(),
+		log:      p.logger(),
+	}
+
+	s.deadline = s.start.Add(p.Timeout)
+	s.timer = time.AfterFunc(p.Timeout, func() {
+		s.timedOut.Store(true)
+		s.stop()
+	})
+
+	s.wg.Add(1)
+	go func() {
+		// Wait for a free slot when config.MaxConcurrentSearches are running.
+		if searchSlots != nil {
+			select {
+			case searchSlots <- struct{}{}:
+				defer func() { <-searchSlots }()
+			case <-s.stopChan:
+				s.wg.Done()
+				return
+			}
+		}
+		s.method.Build(s.root, p.Recipes, p.Count, p.Depth, s.stopChan, &s.wg, &s.mu, &s.nodesVisited, s.treeChan, pace, s.log)
+	}()
+
+	trackSearch(s)
+	searchesInFlight.Inc()
+	select {
+	case <-draining:
+		s.Cancel()
+	default:
+	}
+	go func() {
+		s.wg.Wait()
+		s.timerMu.Lock()
+		s.timer.Stop()
+		if s.pauseTimer != nil {
+			s.pauseTimer.Stop()
+			s.pauseTimer = nil
+		}
+		s.timerMu.Unlock()
+		untrackSearch(s)
+		releaseSearch(p.Client)
+		searchesInFlight.Dec()
+		close(s.treeChan)
+	}()
+	return s, nil
+}
+
+func (s *search) stop() {
+	recipe.StopSearch(s.stopChan, &s.stopOnce)
+}
+
+// Cancel stops the search on behalf of the client.
+func (s *search) Cancel() {
+	s.cancelled.Store(true)
+	s.stop()
+}
+
+// Pause holds the search at its next progress update. The timeout doesn't
+// run while a search is paused, for up to MaxPause over the whole search.
+func (s *search) Pause() {
+	if s.pace == nil {
+		return
+	}
+	s.timerMu.Lock()
+	defer s.timerMu.Unlock()
+	if s.pace.Paused() {
+		return
+	}
+	s.pace.Pause()
+	if s.pausedFor < MaxPause && s.timer.Stop() {
+		s.remaining = time.Until(s.deadline)
+		s.pausedAt = time.Now()
+		s.pauseTimer = time.AfterFunc(MaxPause-s.pausedFor, s.pauseExpired)
+	}
+}
+
+// pauseExpired restarts the timeout of a search that used up MaxPause.
+func (s *search) pauseExpired() {
+	s.timerMu.Lock()
+	defer s.timerMu.Unlock()
+	if s.pauseTimer == nil {
+		return
+	}
+	s.pauseTimer = nil
+	s.pausedFor = MaxPause
+	s.log.Info("search paused for too long, its timeout runs again", "target", s.params.Target, "remaining", s.remaining)
+	s.restartTimer()
+}
+
+// restartTimer runs the timeout again for the time it had left when it was
+// stopped. The caller holds s.timerMu.
+func (s *search) restartTimer() {
+	if s.remaining > 0 {
+		s.deadline = time.Now().Add(s.remaining)
+		s.timer.Reset(s.remaining)
+		s.remaining = 0
+	}
+}
+
+func (s *search) Resume() {
+	if s.pace == nil {
+		return
+	}
+	s.timerMu.Lock()
+	defer s.timerMu.Unlock()
+	if !s.pace.Paused() {
+		return
+	}
+	if s.pauseTimer != nil {
+		s.pauseTimer.Stop()
+		s.pauseTimer = nil
+		s.pausedFor = min(MaxPause, s.pausedFor+time.Since(s.pausedAt))
+	}
+	s.restartTimer()
+	s.pace.Resume()
+}
+
+// progress describes the search at the moment node was sent on treeChan.
+// The builder may still be changing the tree, so the caller holds s.mu.
+func (s *search) progress(node *recipe.RecipeTreeNode) TreeResponse {
+	return TreeResponse{
+		Tree:         buildDTO(node),
+		TimeTaken:    time.Since(s.start).Milliseconds(),
+		NodesVisited: s.nodesVisited,
+		RecipesFound: recipe.CalculateTotalCompleteRecipes(s.root, s.params.Recipes),
+		MethodUsed:   s.method.Name,
+		TimedOut:     s.timedOut.Load(),
+	}
+}
+
+// deltas returns what changed in the tree since tracker last saw it. The
+// tree is locked while it is walked, since the builder is still running.
+func (s *search) deltas(tracker *deltaTracker) []DeltaEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	events := tracker.diff(s.root)
+	return append(events, tracker.update(SearchCounters{
+		TimeTaken:    time.Since(s.start).Milliseconds(),
+		NodesVisited: s.nodesVisited,
+		RecipesFound: recipe.CalculateTotalCompleteRecipes(s.root, s.params.Recipes),
+	})...)
+}
+
+// finish waits for the search to finish, prunes its tree and returns the
+// totals. The tree is pruned under s.mu, since a job's partial result may
+// be read through snapshot until then.
+func (s *search) finish() SearchTotals {
+	s.wg.Wait()
+	elapsed := time.Since(s.start).Milliseconds()
+	s.mu.Lock()
+	recipesFound := recipe.CalculateTotalCompleteRecipes(s.root, s.params.Recipes)
+	recipe.PruneTree(s.root, s.params.Recipes)
+	s.mu.Unlock()
+
+	totals := SearchTotals{
+		TimeTaken:    elapsed,
+		NodesVisited: s.nodesVisited,
+		RecipesFound: recipesFound,
+		MethodUsed:   s.method.Name,
+		TimedOut:     s.timedOut.Load(),
+	}
+	outcome := searchOutcome(totals, s.cancelled.Load())
+	observeSearch(totals, outcome)
+	s.log.Info("search finished",
+		"dataset", s.params.Dataset,
+		"method", s.params.Method,
+		"target", s.params.Target,
+		"count", s.params.Count,
+		"depth", s.params.Depth,
+		"nodes_visited", totals.NodesVisited,
+		"recipes_found", totals.RecipesFound,
+		"duration_ms", totals.TimeTaken,
+		"outcome", outcome)
+	return totals
+}
+
+// result waits for the search to finish and returns its pruned tree.
+func (s *search) result() TreeResponse {
+	totals := s.finish()
+	return TreeResponse{
+		Tree:         buildDTO(s.root),
+		TimeTaken:    totals.TimeTaken,
+		NodesVisited: totals.NodesVisited,
+		RecipesFound: totals.RecipesFound,
+		MethodUsed:   totals.MethodUsed,
+		TimedOut:     totals.TimedOut,
+	}
+}
+
+// finalDeltas waits for the search to finish and returns the last additions,
+// the pruned parts of the tree and the done event.
+func (s *search) finalDeltas(tracker *deltaTracker) []DeltaEvent {
+	s.wg.Wait()
+	events := tracker.diff(s.root)
+	totals := s.finish()
+	events = append(events, tracker.pruned(s.root)...)
+	return append(events, DeltaEvent{Op: DeltaDone, Totals: &totals})
+}
+
+func handleSearch(w http.ResponseWriter, r *http.Request, method string) {
+	if id := r.Header.Get("Last-Event-ID"); id != "" {
+		resumeStream(w, r, id)
+		return
+	}
+
+	p, apiErr := parseSearchParams(r.URL.Query(), method)
+	if apiErr != nil {
+		writeError(w, apiErr)
+		return
+	}
+
+	p.Logger, p.Client = requestLogger(r), clientID(r)
+	p.Logger.Debug("search requested", "method", p.Method, "target", p.Target, "count", p.Count, "depth", p.Depth,
+		"timeout", p.Timeout, "stream", p.Stream, "mode", p.Mode)
+
+	if !p.Stream {
+		res, hit, apiErr := cachedSearch(p)
+		if apiErr != nil {
+			writeError(w, apiErr)
+			return
+		}
+		writeCached(w, r, res, hit)
+		return
+	}
+
+	s, apiErr := startSearch(p, recipe.NewPace(StreamDelay))
+	if apiErr != nil {
+		writeError(w, apiErr)
+		return
+	}
+	streamSearch(w, r, s)
+}
+
+// SearchTree runs a search outside of any request, for the command line, and
+// returns its pruned tree.
+func SearchTree(recipes map[string]recipe.Recipe, target, method string, count, depth int) (*recipe.RecipeTreeNode, error) {
+	if _, ok := recipes[target]; !ok {
+		return nil, fmt.Errorf("unknown element %q", target)
+	}
+	if _, ok := methods[method]; !ok {
+		return nil, fmt.Errorf("unknown method %q, want one of %s", method, strings.Join(methodNames(), ", "))
+	}
+	// Without a client the search is never refused.
+	s, _ := startSearch(searchParams{
+		Recipes: recipes,
+		Target:  target,
+		Method:  method,
+		Count:   count,
+		Depth:   depth,
+		Timeout: DefaultTimeout,
+	}, nil)
+	s.finish()
+	return s.root, nil
+}
@@ -2,14 +2,14 @@ package server
 
 import (
 	"encoding/json"
-	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
-	"strconv"
-	"sync"
+	"strings"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
 	recipe "github.com/Henshou/Tubes2_BE_CraftingTable.git/recipe"
 )
 
@@ -22,12 +22,26 @@ type RecipeDTO struct {
 	Inputs []NodeDTO `json:"inputs"`
 }
 
+type ElementDTO struct {
+	Name        string     `json:"name"`
+	Tier        int        `json:"tier"`
+	ImageURL    string     `json:"imageUrl"`
+	Recipes     [][]string `json:"recipes"`
+	Products    []string   `json:"products"`
+	PageURL     string     `json:"pageUrl,omitempty"`
+	Description string     `json:"description,omitempty"`
+	UsedIn      []string   `json:"usedIn,omitempty"`
+	Categories  []string   `json:"categories,omitempty"`
+	Pack        string     `json:"pack,omitempty"`
+}
+
 type TreeResponse struct {
 	Tree         NodeDTO `json:"tree"`
 	TimeTaken    int64   `json:"timeTaken"` // ms
 	NodesVisited int     `json:"nodesVisited"`
 	RecipesFound int     `json:"recipesFound"`
 	MethodUsed   string  `json:"methodUsed"`
+	TimedOut     bool    `json:"timedOut,omitempty"`
 }
 
 func buildDTO(node *recipe.RecipeTreeNode) NodeDTO {
@@ -49,235 +63,175 @@ func buildDTO(node *recipe.RecipeTreeNode) NodeDTO {
 }
 
 func writeJSON(w http.ResponseWriter, v interface{}) {
-	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Content-Type", "application/json")
 
 	payload, err := json.Marshal(v)
 	if err != nil {
-		log.Printf("[writeJSON] ✗ marshal error: %v\n", err)
-		http.Error(w, "json encode error", http.StatusInternalServerError)
+		slog.Error("cannot encode response", "err", err)
+		writeError(w, &APIError{Status: http.StatusInternalServerError, Code: CodeInternal, Message: "json encode error"})
 		return
 	}
 
-	log.Printf("[writeJSON] → sending %d bytes: %s\n", len(payload), payload)
-
 	if _, err := w.Write(payload); err != nil {
-		log.Printf("[writeJSON] ✗ write error: %v\n", err)
+		slog.Debug("cannot write response", "err", err)
 	}
 }
 
-func parseCount(r *http.Request) int {
-	if s := r.URL.Query().Get("count"); s != "" {
-		if c, err := strconv.Atoi(s); err == nil {
-			return c
-		}
-	}
-	return 1
-}
-
-func parseStream(r *http.Request) bool {
-	return r.URL.Query().Get("stream") == "1"
-}
-
 func recipesHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Content-Type", "application/json")
 
-	data, err := os.ReadFile("recipes.json")
-	if err != nil {
-		http.Error(w, "cannot read recipes.json", http.StatusInternalServerError)
+	name, recipes, apiErr := datasetFor(r)
+	if apiErr != nil {
+		writeError(w, apiErr)
 		return
 	}
-
-	log.Printf("→ [recipesHandler] loaded %d bytes\n", len(data))
-	log.Printf("→ [recipesHandler] preview:\n%s\n", truncate(data, 200))
-
-	w.Write(data)
-}
-
-func dfsHandler(w http.ResponseWriter, r *http.Request) {
-	target := r.URL.Query().Get("target")
-	if target == "" {
-		http.Error(w, "missing target", http.StatusBadRequest)
+	format, apiErr := formatParam(r.URL.Query())
+	if apiErr != nil {
+		writeError(w, apiErr)
 		return
 	}
-	maxRecipes := parseCount(r)
-	streaming := parseStream(r)
-
-	log.Printf("→ [dfsHandler] target=%q maxRecipes=%d stream=%v\n", target, maxRecipes, streaming)
-	recipe.VisitedMap = make(map[string]*recipe.RecipeTreeNode)
-
-	root := &recipe.RecipeTreeNode{Name: target}
-	stopChan := make(chan bool)
-	wg := &sync.WaitGroup{}
-	mu := &sync.Mutex{}
-	treeChan := make(chan *recipe.RecipeTreeNode, 20000000)
-
-	start := time.Now()
-	var nodesVisited int
-
-	go recipe.StopSearch(stopChan, wg)
-
-	wg.Add(1)
-	go recipe.BuildRecipeTreeDFS(root, recipe.RecipeMap, maxRecipes, stopChan, wg, mu, &nodesVisited, treeChan, streaming)
-
-	if streaming {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Content-Type", "text/event-stream")
-		w.Header().Set("Cache-Control", "no-cache")
-		flusher, ok := w.(http.Flusher)
-		if !ok {
-			http.Error(w, "streaming unsupported", http.StatusInternalServerError)
-			return
-		}
-
-		go func() {
-			wg.Wait()
-			close(treeChan)
-		}()
-
-		for node := range treeChan {
-			dto := buildDTO(node)
-			elapsed := time.Since(start).Milliseconds()
-			found := recipe.CalculateTotalCompleteRecipes(root)
-
-			sse := TreeResponse{
-				Tree:         dto,
-				TimeTaken:    elapsed,
-				NodesVisited: nodesVisited,
-				RecipesFound: found,
-				MethodUsed:   "DFS",
-			}
-			data, _ := json.Marshal(sse)
-			fmt.Fprintf(w, "data: %s\n\n", data)
-			flusher.Flush()
-		}
+	if format != FormatJSON {
+		writeRecipeGraph(w, r, name, recipes, format)
 		return
 	}
 
-	go func() {
-		wg.Wait()
-		close(treeChan)
-	}()
-
-	wg.Wait()
-	elapsed := time.Since(start).Milliseconds()
-	recipesFound := recipe.CalculateTotalCompleteRecipes(root)
-	recipe.PruneTree(root)
-	dto := buildDTO(root)
+	data, err := os.ReadFile(dataFile(recipe.DatasetFile(name)))
+	if err != nil {
+		writeError(w, &APIError{Status: http.StatusInternalServerError, Code: CodeInternal, Message: "cannot read " + recipe.DatasetFile(name)})
+		return
+	}
 
-	resp := TreeResponse{
-		Tree:         dto,
-		TimeTaken:    elapsed,
-		NodesVisited: nodesVisited,
-		RecipesFound: recipesFound,
-		MethodUsed:   "DFS",
+	// The file is rewritten by every scrape, so clients must revalidate.
+	w.Header().Set("Cache-Control", "public, no-cache")
+	if notModified(w, r, `"`+contentHash(data)+`"`) {
+		return
 	}
-	writeJSON(w, resp)
+	w.Write(data)
 }
 
-func bfsHandler(w http.ResponseWriter, r *http.Request) {
-	target := r.URL.Query().Get("target")
-	if target == "" {
-		http.Error(w, "missing target", http.StatusBadRequest)
+// writeRecipeGraph exports a whole dataset as a graph. The export only
+// changes with the dataset, so its ETag is derived from the dataset version.
+func writeRecipeGraph(w http.ResponseWriter, r *http.Request, name string, recipes map[string]recipe.Recipe, format string) {
+	w.Header().Set("Cache-Control", "public, no-cache")
+	if notModified(w, r, `"`+datasetVersion(name)+"-"+format+`"`) {
 		return
 	}
-	maxRecipes := parseCount(r)
-	streaming := parseStream(r)
-
-	log.Printf("→ [bfsHandler] target=%q maxRecipes=%d stream=%v\n", target, maxRecipes, streaming)
-
-	recipe.VisitedMap = make(map[string]*recipe.RecipeTreeNode)
-
-	root := &recipe.RecipeTreeNode{Name: target}
-	stopChan := make(chan bool)
-	wg := &sync.WaitGroup{}
-	mu := &sync.Mutex{}
-	treeChan := make(chan *recipe.RecipeTreeNode, 20000000)
-
-	start := time.Now()
-	var nodesVisited int
-
-	go recipe.StopSearch(stopChan, wg)
-	wg.Add(1)
-	go recipe.BuildRecipeTreeBFS(root, recipe.RecipeMap, maxRecipes, stopChan, wg, mu, &nodesVisited, treeChan, streaming)
-
-	if streaming {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Content-Type", "text/event-stream")
-		w.Header().Set("Cache-Control", "no-cache")
-		flusher, ok := w.(http.Flusher)
-		if !ok {
-			http.Error(w, "streaming unsupported", http.StatusInternalServerError)
-			return
-		}
-
-		go func() {
-			wg.Wait()
-			close(treeChan)
-		}()
-
-		for node := range treeChan {
-			dto := buildDTO(node)
-			elapsed := time.Since(start).Milliseconds()
-			found := recipe.CalculateTotalCompleteRecipes(root)
+	w.Header().Set("Content-Type", recipe.ContentType(format))
+	if err := recipe.WriteGraph(w, recipe.RecipeGraph(recipes), format); err != nil {
+		requestLogger(r).Error("cannot export recipes", "dataset", name, "format", format, "err", err)
+	}
+}
 
-			sse := TreeResponse{
-				Tree:         dto,
-				TimeTaken:    elapsed,
-				NodesVisited: nodesVisited,
-				RecipesFound: found,
-				MethodUsed:   "BFS",
-			}
-			data, _ := json.Marshal(sse)
-			fmt.Fprintf(w, "data: %s\n\n", data)
-			flusher.Flush()
+// Start serves the API on cfg.Addr until the process is stopped. prepare,
+// if set, runs before the datasets are loaded, for instance to scrape them;
+// the server listens meanwhile and reports not ready.
+func Start(cfg Config, prepare func() error) {
+	config = cfg
+	ConfigureLogging(cfg)
+	if cfg.APIKeyFile != "" {
+		if err := loadAPIKeys(cfg.APIKeyFile); err != nil {
+			fatal("cannot load the API keys", err)
 		}
-		return
+		slog.Info("loaded API keys", "keys", len(apiKeys), "require_key", cfg.RequireKey)
 	}
+	if cfg.MaxConcurrentSearches > 0 {
+		searchSlots = make(chan struct{}, cfg.MaxConcurrentSearches)
+	}
+	startJobWorkers(cfg.JobWorkers)
 
-	go func() {
-		wg.Wait()
-		close(treeChan)
-	}()
-
-	wg.Wait()
-	elapsed := time.Since(start).Milliseconds()
-	recipesFound := recipe.CalculateTotalCompleteRecipes(root)
-	recipe.PruneTree(root)
-	dto := buildDTO(root)
+	if err := checkSpec(registerRoutes(http.DefaultServeMux)); err != nil {
+		fatal("invalid API description", err)
+	}
 
-	resp := TreeResponse{
-		Tree:         dto,
-		TimeTaken:    elapsed,
-		NodesVisited: nodesVisited,
-		RecipesFound: recipesFound,
-		MethodUsed:   "BFS",
+	srv := &http.Server{
+		Addr:         cfg.Addr,
+		Handler:      withRequestLog(withMetrics(withCORS(withLoading(withRateLimit(withAuth(http.DefaultServeMux)))))),
+		ReadTimeout:  cfg.ReadTimeout,
+		WriteTimeout: cfg.WriteTimeout,
+		IdleTimeout:  cfg.IdleTimeout,
 	}
-	writeJSON(w, resp)
+	slog.Info("server listening", "addr", cfg.Addr)
+	go load(prepare)
+	serve(srv)
 }
 
-func Start() {
-	var err error
-	recipe.RecipeMap, err = recipe.ReadJson("recipes.json")
-	if err != nil {
-		log.Fatalf("Failed to load recipes.json: %v", err)
+// registerRoutes registers every handler on mux and returns the routes with
+// their methods, for checkSpec.
+func registerRoutes(mux *http.ServeMux) []route {
+	const get, post, del = http.MethodGet, http.MethodPost, http.MethodDelete
+	t := &routeTable{mux: mux}
+	t.handleFunc("/healthz", healthzHandler, get)
+	t.handleFunc("/readyz", readyzHandler, get)
+	t.handleFunc("/metrics", promhttp.Handler().ServeHTTP, get)
+	t.handleFunc("/api/openapi.json", openAPIHandler, get)
+	t.handleFunc("/api/datasets", datasetsHandler, get)
+	t.handleFunc("/api/recipes", recipesHandler, get)
+	t.handleFunc("/api/elements", elementsHandler, get)
+	t.handleFunc("/api/elements/{name}", elementHandler, get)
+	t.handleFunc("/api/images/{element}", imageHandler, get)
+	t.handleFunc("/api/search", searchHandler, get)
+	t.handleFunc("/api/search/batch", batchHandler, post)
+	t.handleFunc("/api/compare", compareHandler, get)
+	t.handleFunc("/api/render", renderHandler, get)
+	t.handleFunc("/api/ws", wsHandler, get)
+	t.handleFunc("/api/jobs", jobsHandler, get, post)
+	t.handleFunc("/api/jobs/{id}", jobHandler, get, del)
+	t.handleFunc("/api/graphql", graphQLHandler, get, post)
+	t.handleFunc("/api/dfs", dfsHandler, get)
+	t.handleFunc("/api/bfs", bfsHandler, get)
+	t.handleFunc("/api/admin/reload", requireAdmin(reloadHandler), post)
+	t.handleFunc("/api/admin/scrape", requireAdmin(scrapeHandler), get, post)
+	t.handleFunc("/api/admin/cache", requireAdmin(cacheHandler), del)
+	t.handleFunc("/api/admin/jobs", requireAdmin(adminJobsHandler), get)
+	t.handleFunc("/api/bidirectional", func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, &APIError{Status: http.StatusNotImplemented, Code: CodeNotImplemented, Message: "Bidirectional not implemented"})
+	}, get)
+	return t.routes
+}
+
+// extendWriteDeadline gives a handler that may search for up to d before it
+// writes its response config.WriteTimeout on top of d to do so.
+func extendWriteDeadline(w http.ResponseWriter, r *http.Request, d time.Duration) {
+	if config.WriteTimeout == 0 {
+		return
+	}
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Now().Add(d + config.WriteTimeout)); err != nil {
+		requestLogger(r).Debug("cannot extend the write deadline", "err", err)
 	}
+}
 
-	http.HandleFunc("/api/recipes", recipesHandler)
-	http.HandleFunc("/api/dfs", dfsHandler)
-	http.HandleFunc("/api/bfs", bfsHandler)
-	http.HandleFunc("/api/bidirectional", func(w http.ResponseWriter, r *http.Request) {
-		http.Error(w, "Bidirectional not implemented", http.StatusNotImplemented)
+// withCORS lets the origins in config.AllowedOrigins call the API and
+// answers their preflight requests.
+func withCORS(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if origin := allowedOrigin(r.Header.Get("Origin")); origin != "" {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Expose-Headers", "ETag, Location, Retry-After, X-Cache, X-Request-ID")
+			if origin != "*" {
+				w.Header().Add("Vary", "Origin")
+			}
+		}
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, If-None-Match, Last-Event-ID, X-API-Key, X-Request-ID")
+			w.Header().Set("Access-Control-Max-Age", "600")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
 	})
-
-	log.Println("Server listening on :8080")
-	log.Fatal(http.ListenAndServe(":8080", nil))
 }
 
-func truncate(b []byte, n int) string {
-	if len(b) <= n {
-		return string(b)
+// allowedOrigin is the value of Access-Control-Allow-Origin for a request
+// from origin, or "" if that origin isn't allowed.
+func allowedOrigin(origin string) string {
+	for _, allowed := range config.AllowedOrigins {
+		if allowed == "*" {
+			return "*"
+		}
+		if origin != "" && strings.EqualFold(allowed, origin) {
+			return origin
+		}
 	}
-	return string(b[:n]) + "...(truncated)"
+	return ""
 }
@@ -0,0 +1,299 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	SSEProgress    = "progress"
+	SSERecipeFound = "recipe-found"
+	SSEDone        = "done"
+	SSEError       = "error"
+	SSEHeartbeat   = "heartbeat"
+
+	HeartbeatInterval = 15 * time.Second
+	ReconnectDelay    = 2 * time.Second
+	// StreamRetention is how long a finished stream can still be resumed.
+	StreamRetention   = 5 * time.Minute
+	MaxBufferedEvents = 10000
+)
+
+type sseEvent struct {
+	Seq  int
+	Name string
+	Data []byte
+}
+
+// eventStream buffers the events of one streamed search, so a client that
+// reconnects with Last-Event-ID can pick up where it left off. Event ids are
+// "<stream id>-<sequence number>".
+type eventStream struct {
+	ID string
+
+	mu       sync.Mutex
+	events   []sseEvent
+	next     int
+	closed   bool
+	closedAt time.Time
+	updated  chan struct{}
+}
+
+var streams = struct {
+	sync.Mutex
+	byID map[string]*eventStream
+}{byID: make(map[string]*eventStream)}
+
+func newEventStream() *eventStream {
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	es := &eventStream{ID: hex.EncodeToString(buf), next: 1, updated: make(chan struct{})}
+
+	streams.Lock()
+	defer streams.Unlock()
+	for id, old := range streams.byID {
+		if old.expired() {
+			delete(streams.byID, id)
+		}
+	}
+	streams.byID[es.ID] = es
+	return es
+}
+
+// findStream parses a Last-Event-ID header, returning the stream it belongs
+// to and the sequence number the client saw last.
+func findStream(lastEventID string) (*eventStream, int, bool) {
+	id, seqStr, ok := strings.Cut(lastEventID, "-")
+	if !ok {
+		return nil, 0, false
+	}
+	seq, err := strconv.Atoi(seqStr)
+	if err != nil {
+		return nil, 0, false
+	}
+	streams.Lock()
+	defer streams.Unlock()
+	es, ok := streams.byID[id]
+	if !ok || es.expired() {
+		return nil, 0, false
+	}
+	return es, seq, true
+}
+
+func (es *eventStream) expired() bool {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	return es.closed && time.Since(es.closedAt) > StreamRetention
+}
+
+func (es *eventStream) publish(name string, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		slog.Error("cannot encode event", "event", name, "err", err)
+		name = SSEError
+		data, _ = json.Marshal(ErrorResponse{Error: &APIError{Code: CodeInternal, Message: "json encode error"}})
+	}
+
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	es.events = append(es.events, sseEvent{Seq: es.next, Name: name, Data: data})
+	es.next++
+	if len(es.events) > MaxBufferedEvents {
+		es.events = es.events[len(es.events)-MaxBufferedEvents:]
+	}
+	close(es.updated)
+	es.updated = make(chan struct{})
+}
+
+func (es *eventStream) close() {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	es.closed = true
+	es.closedAt = time.Now()
+	close(es.updated)
+	es.updated = make(chan struct{})
+}
+
+// since returns the buffered events after seq. ok is false when some of
+// them were already dropped from the buffer.
+func (es *eventStream) since(seq int) (events []sseEvent, updated chan struct{}, closed, ok bool) {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	if len(es.events) > 0 && es.events[0].Seq > seq+1 {
+		return nil, nil, es.closed, false
+	}
+	for i, ev := range es.events {
+		if ev.Seq > seq {
+			events = append(events, es.events[i:]...)
+			break
+		}
+	}
+	return events, es.updated, es.closed, true
+}
+
+// serve writes the events after seq to w, then follows the stream until it
+// closes or the client goes away, sending heartbeats while it is idle.
+func (es *eventStream) serve(w http.ResponseWriter, r *http.Request, flusher http.Flusher, seq int) {
+	heartbeat := time.NewTicker(HeartbeatInterval)
+	defer heartbeat.Stop()
+	sseConnections.Inc()
+	defer sseConnections.Dec()
+
+	for {
+		events, updated, closed, ok := es.since(seq)
+		if !ok {
+			writeSSEError(w, &APIError{Code: CodeStreamExpired, Message: "events after " + strconv.Itoa(seq) + " are no longer buffered"})
+			flusher.Flush()
+			return
+		}
+		for _, ev := range events {
+			fmt.Fprintf(w, "id: %s-%d\nevent: %s\ndata: %s\n\n", es.ID, ev.Seq, ev.Name, ev.Data)
+			seq = ev.Seq
+		}
+		flusher.Flush()
+		if closed {
+			return
+		}
+
+		select {
+		case <-updated:
+		case <-heartbeat.C:
+			fmt.Fprintf(w, "event: %s\ndata: {}\n\n", SSEHeartbeat)
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func startSSE(w http.ResponseWriter) (http.Flusher, bool) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, &APIError{Status: http.StatusInternalServerError, Code: CodeStreamingUnsupported, Message: "streaming unsupported"})
+		return nil, false
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	fmt.Fprintf(w, "retry: %d\n\n", ReconnectDelay.Milliseconds())
+	return flusher, true
+}
+
+func writeSSEError(w http.ResponseWriter, err *APIError) {
+	data, _ := json.Marshal(ErrorResponse{Error: err})
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", SSEError, data)
+}
+
+// resumeStream answers a reconnecting EventSource. A finished stream with
+// nothing left to send gets 204, which stops the browser from reconnecting,
+// and so does a stream that is unknown, has expired or no longer buffers
+// the events the client missed: it could only send the same error again.
+func resumeStream(w http.ResponseWriter, r *http.Request, lastEventID string) {
+	es, seq, ok := findStream(lastEventID)
+	if ok {
+		events, _, closed, buffered := es.since(seq)
+		if closed && len(events) == 0 {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		ok = buffered
+	}
+	if !ok {
+		requestLogger(r).Debug("stream can't be resumed", "last_event_id", lastEventID)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	flusher, streaming := startSSE(w)
+	if !streaming {
+		return
+	}
+	requestLogger(r).Debug("resuming stream", "stream", es.ID, "after", seq)
+	es.serve(w, r, flusher, seq)
+}
+
+// streamSearch runs s in the background, publishing its progress to a new
+// event stream, and serves that stream to the client.
+func streamSearch(w http.ResponseWriter, r *http.Request, s *search) {
+	flusher, ok := startSSE(w)
+	if !ok {
+		s.Cancel()
+		s.finish()
+		return
+	}
+
+	es := newEventStream()
+	go func() {
+		defer es.close()
+		// shuttingDown tells the client why its search ended early, before
+		// the done event.
+		shuttingDown := func() {
+			select {
+			case <-draining:
+				if s.cancelled.Load() {
+					es.publish(SSEError, ErrorResponse{Error: &APIError{Code: CodeShuttingDown, Message: "the server is shutting down"}})
+				}
+			default:
+			}
+		}
+		found := 0
+		recipeFound := func(counters SearchCounters) {
+			if counters.RecipesFound > found {
+				found = counters.RecipesFound
+				es.publish(SSERecipeFound, counters)
+			}
+		}
+
+		if s.params.Mode == StreamModeDelta {
+			tracker := newDeltaTracker()
+			publish := func(events []DeltaEvent) {
+				for _, ev := range events {
+					switch ev.Op {
+					case DeltaCounters:
+						es.publish(SSEProgress, ev)
+						recipeFound(*ev.Counters)
+					case DeltaDone:
+						recipeFound(ev.Totals.counters())
+						es.publish(SSEDone, ev)
+					default:
+						es.publish(SSEProgress, ev)
+					}
+				}
+			}
+			for range s.treeChan {
+				publish(s.deltas(tracker))
+			}
+			events := s.finalDeltas(tracker)
+			shuttingDown()
+			publish(events)
+			return
+		}
+
+		for range s.treeChan {
+			progress := s.snapshot()
+			es.publish(SSEProgress, progress)
+			recipeFound(SearchCounters{
+				TimeTaken:    progress.TimeTaken,
+				NodesVisited: progress.NodesVisited,
+				RecipesFound: progress.RecipesFound,
+			})
+		}
+		result := s.result()
+		shuttingDown()
+		recipeFound(SearchCounters{
+			TimeTaken:    result.TimeTaken,
+			NodesVisited: result.NodesVisited,
+			RecipesFound: result.RecipesFound,
+		})
+		es.publish(SSEDone, result)
+	}()
+
+	es.serve(w, r, flusher, 0)
+}
@@ -0,0 +1,244 @@
+package server
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	recipe "github.com/Henshou/Tubes2_BE_CraftingTable.git/recipe"
+)
+
+// WSCommand is a message from the client. Type is one of start, pause,
+// resume, speed or cancel; the other fields only matter for start, except
+// Delay which speed uses too.
+type WSCommand struct {
+	Type    string `json:"type"`
+	Dataset string `json:"dataset,omitempty"`
+	Target  string `json:"target,omitempty"`
+	Method  string `json:"method,omitempty"`
+	Count   int    `json:"count,omitempty"`
+	Depth   int    `json:"depth,omitempty"`
+	Timeout string `json:"timeout,omitempty"`
+	Mode    string `json:"mode,omitempty"`
+	Delay   *int   `json:"delay,omitempty"` // ms between progress events
+}
+
+// WSEvent is a message to the client: progress and done carry a
+// TreeResponse, delta the changes of a delta mode search, error an
+// APIError, and state reports running, paused or cancelled after a command.
+type WSEvent struct {
+	Type   string        `json:"type"`
+	Data   *TreeResponse `json:"data,omitempty"`
+	Deltas []DeltaEvent  `json:"deltas,omitempty"`
+	State  string        `json:"state,omitempty"`
+	Delay  int64         `json:"delay,omitempty"`
+	Error  *APIError     `json:"error,omitempty"`
+}
+
+var upgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		return origin == "" || allowedOrigin(origin) != ""
+	},
+}
+
+type wsSession struct {
+	conn    *websocket.Conn
+	log     *slog.Logger
+	client  string
+	writeMu sync.Mutex
+
+	mu     sync.Mutex
+	search *search
+}
+
+func wsHandler(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		requestLogger(r).Debug("websocket upgrade failed", "err", err)
+		return
+	}
+	defer conn.Close()
+	trackConn(conn)
+	defer untrackConn(conn)
+
+	sess := &wsSession{conn: conn, log: requestLogger(r), client: clientID(r)}
+	defer sess.cancel()
+
+	for {
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				sess.log.Debug("websocket read failed", "err", err)
+			}
+			return
+		}
+		// A command that can't be decoded is the client's mistake, not the
+		// connection's: report it and keep reading.
+		var cmd WSCommand
+		if err := json.Unmarshal(msg, &cmd); err != nil {
+			sess.sendError(&APIError{Status: http.StatusBadRequest, Code: CodeInvalidBody, Message: "invalid JSON command: " + err.Error()})
+			continue
+		}
+		sess.handle(cmd)
+	}
+}
+
+func (sess *wsSession) send(ev WSEvent) {
+	sess.writeMu.Lock()
+	defer sess.writeMu.Unlock()
+	if err := sess.conn.WriteJSON(ev); err != nil {
+		sess.log.Debug("websocket write failed", "err", err)
+	}
+}
+
+func (sess *wsSession) sendError(err *APIError) {
+	sess.send(WSEvent{Type: "error", Error: err})
+}
+
+func (sess *wsSession) current() *search {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	return sess.search
+}
+
+func (sess *wsSession) cancel() {
+	if s := sess.current(); s != nil {
+		s.Cancel()
+	}
+}
+
+func (sess *wsSession) handle(cmd WSCommand) {
+	if cmd.Type == "start" {
+		sess.start(cmd)
+		return
+	}
+
+	s := sess.current()
+	if s == nil {
+		sess.sendError(&APIError{Status: http.StatusConflict, Code: CodeNoSearch, Message: "no search is running"})
+		return
+	}
+
+	switch cmd.Type {
+	case "pause":
+		s.Pause()
+		sess.send(WSEvent{Type: "state", State: "paused"})
+	case "resume":
+		s.Resume()
+		sess.send(WSEvent{Type: "state", State: "running"})
+	case "speed":
+		delay, apiErr := wsDelay(cmd)
+		if apiErr != nil {
+			sess.sendError(apiErr)
+			return
+		}
+		s.pace.SetDelay(delay)
+		sess.send(WSEvent{Type: "state", State: "running", Delay: delay.Milliseconds()})
+	case "cancel":
+		s.Cancel()
+	default:
+		sess.sendError(invalidParameter(ParameterDetails{Parameter: "type", Value: cmd.Type,
+			Allowed: []string{"start", "pause", "resume", "speed", "cancel"}}, "unknown command %q", cmd.Type))
+	}
+}
+
+func wsDelay(cmd WSCommand) (time.Duration, *APIError) {
+	if cmd.Delay == nil {
+		return StreamDelay, nil
+	}
+	delay := time.Duration(*cmd.Delay) * time.Millisecond
+	if delay < 0 || delay > MaxStreamDelay {
+		return 0, invalidParameter(ParameterDetails{Parameter: "delay", Value: strconv.Itoa(*cmd.Delay), Min: 0, Max: MaxStreamDelay.Milliseconds()},
+			"delay must be between 0 and %d ms", MaxStreamDelay.Milliseconds())
+	}
+	return delay, nil
+}
+
+func (sess *wsSession) start(cmd WSCommand) {
+	query := url.Values{}
+	query.Set("dataset", cmd.Dataset)
+	query.Set("target", cmd.Target)
+	query.Set("method", cmd.Method)
+	query.Set("timeout", cmd.Timeout)
+	query.Set("mode", cmd.Mode)
+	if cmd.Count != 0 {
+		query.Set("count", strconv.Itoa(cmd.Count))
+	}
+	if cmd.Depth != 0 {
+		query.Set("depth", strconv.Itoa(cmd.Depth))
+	}
+	p, apiErr := parseSearchParams(query, "")
+	if apiErr != nil {
+		sess.sendError(apiErr)
+		return
+	}
+	delay, apiErr := wsDelay(cmd)
+	if apiErr != nil {
+		sess.sendError(apiErr)
+		return
+	}
+
+	sess.mu.Lock()
+	if sess.search != nil {
+		sess.mu.Unlock()
+		sess.sendError(&APIError{Status: http.StatusConflict, Code: CodeSearchRunning, Message: "a search is already running"})
+		return
+	}
+	p.Stream = true
+	p.Logger, p.Client = sess.log, sess.client
+	s, apiErr := startSearch(p, recipe.NewPace(delay))
+	if apiErr != nil {
+		sess.mu.Unlock()
+		sess.sendError(apiErr)
+		return
+	}
+	sess.search = s
+	sess.mu.Unlock()
+
+	sess.log.Debug("search requested", "method", p.Method, "target", p.Target, "count", p.Count, "depth", p.Depth, "timeout", p.Timeout)
+	sess.send(WSEvent{Type: "state", State: "running", Delay: delay.Milliseconds()})
+
+	go func() {
+		var last WSEvent
+		if p.Mode == StreamModeDelta {
+			tracker := newDeltaTracker()
+			for range s.treeChan {
+				if deltas := s.deltas(tracker); len(deltas) > 0 {
+					sess.send(WSEvent{Type: "delta", Deltas: deltas})
+				}
+			}
+			last = WSEvent{Type: "delta", Deltas: s.finalDeltas(tracker)}
+		} else {
+			for range s.treeChan {
+				progress := s.snapshot()
+				sess.send(WSEvent{Type: "progress", Data: &progress})
+			}
+			// result records and logs the search, so it runs even when
+			// nobody wants the tree any more.
+			result := s.result()
+			if !s.cancelled.Load() {
+				last = WSEvent{Type: "done", Data: &result}
+			}
+		}
+
+		// The session is free again before the client hears the search is
+		// over, so a start sent right after done isn't refused.
+		sess.mu.Lock()
+		sess.search = nil
+		sess.mu.Unlock()
+
+		if last.Type != "" {
+			sess.send(last)
+		}
+		if s.cancelled.Load() {
+			sess.send(WSEvent{Type: "state", State: "cancelled"})
+		}
+	}()
+}